@@ -0,0 +1,48 @@
+package jsonrpc
+
+import "context"
+
+// Caller makes synchronous JSON-RPC calls. Client, WebSocketClient, StreamPeer, and Pool are all Callers.
+type Caller interface {
+	Call(ctx context.Context, method string, params interface{}, result interface{}) error
+}
+
+// Call sends a JSON-RPC request through the connection denoted by the connection ID and blocks until the response arrives or given context is done.
+// Response result is deserialized into result object, which should be passed by reference, or can be nil if the result is not needed.
+// If the response carries an error object, it is returned as a *ResError. Message metadata carried by the context is sent along with the request.
+func (s *Sender) Call(ctx context.Context, connID string, method string, params interface{}, result interface{}) error {
+	return call(ctx, result, func(resHandler func(ctx *ResCtx) error) (string, error) {
+		return s.SendRequestContext(ctx, connID, method, params, resHandler)
+	}, s.forgetRequest)
+}
+
+// forgetRequest removes the response handler of a request which is not waited for anymore.
+func (s *Sender) forgetRequest(reqID string) {
+	s.resRoutes.take(reqID)
+}
+
+// call sends a request with given send function and waits for its response, or calls forget with the request ID if the context is done first.
+func call(ctx context.Context, result interface{}, send func(resHandler func(ctx *ResCtx) error) (reqID string, err error), forget func(reqID string)) error {
+	done := make(chan error, 1)
+	id, err := send(func(rc *ResCtx) error {
+		if resErr := rc.Err; resErr != nil {
+			done <- resErr
+		} else if result != nil {
+			done <- rc.Result(result)
+		} else {
+			done <- nil
+		}
+		return rc.Next()
+	})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		forget(id)
+		return ctx.Err()
+	}
+}
@@ -1,65 +1,116 @@
 package jsonrpc
 
 import (
+	"context"
+	"errors"
 	"sync"
 
 	"github.com/neptulon/cmap"
 	"github.com/neptulon/neptulon"
 )
 
-// Client is a Neptulon JSON-RPC client.
+// Client is a JSON-RPC client. It is backed by a Neptulon connection unless created with UseConn.
 type Client struct {
 	Middleware
-	Conn *neptulon.Conn
+	Conn Conn // Underlying transport connection.
 
-	sender Sender
-	client *neptulon.Client // inner Neptulon client
-	router *Router
+	sender   Sender
+	client   *neptulon.Client // inner Neptulon client (if any)
+	router   *Router
+	done     chan struct{} // closed when the connection is lost
+	doneOnce sync.Once
 }
 
 // NewClient creates a new Client object.
 // msgWG = (optional) sets the given *sync.WaitGroup reference to be used for counting active gorotuines that are used for handling incoming/outgoing messages.
 // disconnHandler = (optional) registers a function to handle client disconnection events.
 func NewClient(msgWG *sync.WaitGroup, disconnHandler func(client *neptulon.Client)) *Client {
-	return UseClient(neptulon.NewClient(msgWG, disconnHandler))
+	var c *Client
+	c = UseClient(neptulon.NewClient(msgWG, func(client *neptulon.Client) {
+		c.disconnected()
+		if disconnHandler != nil {
+			disconnHandler(client)
+		}
+	}))
+	return c
 }
 
 // UseClient wraps an established Neptulon Client into a JSON-RPC Client.
 func UseClient(client *neptulon.Client) *Client {
-	c := Client{
-		Conn:   client.Conn,
-		client: client,
-	}
+	c := UseConn(neptulonConn{client})
+	c.client = client
 	c.client.MiddlewareIn(c.Middleware.neptulonMiddleware)
-	c.sender = NewSender(&c.Middleware, func(connID string, msg []byte) error { return c.client.Send(msg) })
+	return c
+}
+
+// UseConn wraps an established connection of any transport into a JSON-RPC Client.
+// Neptulon specific functions (i.e. Connect, UseTLS) are not available on such Client.
+// Incoming messages are not received until Serve is called with the same connection.
+func UseConn(conn Conn) *Client {
+	c := Client{Conn: conn, done: make(chan struct{})}
+	c.sender = NewSender(&c.Middleware, func(connID string, msg []byte) error { return c.Conn.Send(msg) })
 	return &c
 }
 
+// Serve receives messages from the given connection until it is closed. See Middleware.Serve for details.
+func (c *Client) Serve(conn Conn) error {
+	defer c.disconnected()
+	return c.Middleware.Serve(conn)
+}
+
+// Peer returns the client end of the connection as a Peer, which is also passed to the handlers of incoming messages as ctx.Peer.
+func (c *Client) Peer() *Peer {
+	return c.Middleware.peer(c.Conn)
+}
+
+// Done returns a channel that is closed when the connection is lost.
+// Only works for clients created with NewClient or the ones receiving messages with Serve.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *Client) disconnected() {
+	c.doneOnce.Do(func() {
+		c.forgetConn(c.Conn.ConnID())
+		close(c.done)
+	})
+}
+
 // ConnID is a randomly generated unique client connection ID.
 func (c *Client) ConnID() string {
-	return c.client.ConnID()
+	return c.Conn.ConnID()
 }
 
 // Session is a thread-safe data store for storing arbitrary data for this connection session.
 func (c *Client) Session() *cmap.CMap {
-	return c.client.Session()
+	return c.Conn.Session()
 }
 
 // SetDeadline set the read/write deadlines for the connection, in seconds.
+// Has no effect on clients that are not backed by a Neptulon connection.
 func (c *Client) SetDeadline(seconds int) {
-	c.client.SetDeadline(seconds)
+	if c.client != nil {
+		c.client.SetDeadline(seconds)
+	}
 }
 
 // UseTLS enables Transport Layer Security for the connection.
 // ca = Optional CA certificate to be used for verifying the server certificate. Useful for using self-signed server certificates.
 // clientCert, clientCertKey = Optional certificate/privat key pair for TLS client certificate authentication.
 // All certificates/private keys are in PEM encoded X.509 format.
+// Has no effect on clients that are not backed by a Neptulon connection.
 func (c *Client) UseTLS(ca, clientCert, clientCertKey []byte) {
-	c.client.UseTLS(ca, clientCert, clientCertKey)
+	if c.client != nil {
+		c.client.UseTLS(ca, clientCert, clientCertKey)
+	}
 }
 
 // Connect connectes to the server at given network address and starts receiving messages.
 func (c *Client) Connect(addr string, debug bool) error {
+	if c.client == nil {
+		return errors.New("client is not backed by a Neptulon connection")
+	}
+
 	return c.client.Connect(addr, debug)
 }
 
@@ -69,10 +120,26 @@ func (c *Client) SendRequest(method string, params interface{}, resHandler func(
 	return c.sender.SendRequest("", method, params, resHandler)
 }
 
+// SendRequestContext is the same as SendRequest, except that the message metadata carried by given context (if any) is sent along with the request.
+func (c *Client) SendRequestContext(ctx context.Context, method string, params interface{}, resHandler func(ctx *ResCtx) error) (reqID string, err error) {
+	return c.sender.SendRequestContext(ctx, "", method, params, resHandler)
+}
+
+// Pending returns the number of requests sent through the client connection which are still waiting for a response.
+func (c *Client) Pending() int {
+	return c.sender.Pending()
+}
+
 // SendRequestArr sends a JSON-RPC request through the client connection, with array params and auto generated request ID.
 // resHandler is called when a response is returned.
 func (c *Client) SendRequestArr(method string, resHandler func(ctx *ResCtx) error, params ...interface{}) (reqID string, err error) {
-	return c.sender.SendRequestArr("", method, resHandler, params)
+	return c.sender.SendRequestArr("", method, resHandler, params...)
+}
+
+// Call sends a JSON-RPC request through the client connection and blocks until the response arrives or given context is done.
+// See Sender.Call for details.
+func (c *Client) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	return c.sender.Call(ctx, "", method, params, result)
 }
 
 // SendNotification sends a JSON-RPC notification through the client connection with structured params object.
@@ -80,9 +147,14 @@ func (c *Client) SendNotification(method string, params interface{}) error {
 	return c.sender.SendNotification("", method, params)
 }
 
+// SendNotificationContext is the same as SendNotification, except that the message metadata carried by given context (if any) is sent along with the notification.
+func (c *Client) SendNotificationContext(ctx context.Context, method string, params interface{}) error {
+	return c.sender.SendNotificationContext(ctx, "", method, params)
+}
+
 // SendNotificationArr sends a JSON-RPC notification message through the client connection with array params.
 func (c *Client) SendNotificationArr(method string, params ...interface{}) error {
-	return c.sender.SendNotificationArr("", method, params)
+	return c.sender.SendNotificationArr("", method, params...)
 }
 
 // SendResponse sends a JSON-RPC response through the client connection.
@@ -91,20 +163,26 @@ func (c *Client) SendResponse(id string, result interface{}, err *ResError) erro
 }
 
 // HandleRequest regiters a handler for incoming requests.
-func (c *Client) HandleRequest(route string, handler func(ctx *ReqCtx) error) {
+func (c *Client) HandleRequest(route string, handler func(ctx *ReqCtx) error, middleware ...func(ctx *ReqCtx) error) {
 	c.lazyRegisterRouter()
-	c.router.Request(route, handler)
+	c.router.Request(route, handler, middleware...)
 }
 
 // HandleNotification regiters a handler for incoming notifications.
-func (c *Client) HandleNotification(route string, handler func(ctx *NotCtx) error) {
+func (c *Client) HandleNotification(route string, handler func(ctx *NotCtx) error, middleware ...func(ctx *NotCtx) error) {
+	c.lazyRegisterRouter()
+	c.router.Notification(route, handler, middleware...)
+}
+
+// Subscribe adds a listener for incoming notifications, alongside the handler and the other listeners of the route. See Router.Subscribe for details.
+func (c *Client) Subscribe(route string, listener func(ctx *NotCtx) error) (unsubscribe func()) {
 	c.lazyRegisterRouter()
-	c.router.Notification(route, handler)
+	return c.router.Subscribe(route, listener)
 }
 
 // Close closes a client connection.
 func (c *Client) Close() error {
-	return c.client.Close()
+	return c.Conn.Close()
 }
 
 // Router middleware needs to be registered last for other middleware to be relevant.
@@ -0,0 +1,316 @@
+// Command jsonrpc-gen generates a typed JSON-RPC client and a server adapter for a Go interface.
+//
+// Every method of the interface should be of the form:
+//
+//	Method(ctx context.Context, args...) (R, error)
+//	Method(ctx context.Context, args...) error
+//
+// Arguments are sent as a by-name params object keyed with the argument names, and methods are named as "prefix.method".
+// Methods returning only an error are answered with an empty object as the result.
+// Usage with go generate, next to the interface declaration:
+//
+//	//go:generate go run github.com/neptulon/jsonrpc/cmd/jsonrpc-gen -type UserService -prefix users
+//
+// which writes userservice_jsonrpc.go with:
+//
+//	type UserServiceClient struct { ... }                                       // implements UserService over a jsonrpc.Caller
+//	func NewUserServiceClient(c jsonrpc.Caller) *UserServiceClient
+//	func RegisterUserService(r *jsonrpc.Router, svc UserService) error          // registers svc methods as typed request routes
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+const jsonrpcPath = "github.com/neptulon/jsonrpc"
+
+var (
+	typeName = flag.String("type", "", "name of the interface to generate the client and server adapter for (required)")
+	prefix   = flag.String("prefix", "", "method name prefix (default: interface name with lowercase first letter)")
+	output   = flag.String("output", "", "output file name (default: <type>_jsonrpc.go, or <type>_jsonrpc_test.go for test files)")
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("jsonrpc-gen: ")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: jsonrpc-gen -type T [-prefix p] [-output file] [file.go]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	file := os.Getenv("GOFILE") // set by go generate
+	if flag.NArg() > 0 {
+		file = flag.Arg(0)
+	}
+	if *typeName == "" || file == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	svc, err := parseService(file, *typeName, *prefix)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	src, err := generate(svc)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	out := *output
+	if out == "" {
+		out = strings.ToLower(svc.Name) + "_jsonrpc.go"
+		if strings.HasSuffix(file, "_test.go") {
+			out = strings.ToLower(svc.Name) + "_jsonrpc_test.go"
+		}
+		out = filepath.Join(filepath.Dir(file), out)
+	}
+	if err := os.WriteFile(out, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// service describes the parsed interface.
+type service struct {
+	Package string
+	Name    string
+	Prefix  string
+	Imports []string // import specs, i.e. `"time"` or `foo "example.com/foo"`
+	Methods []method
+}
+
+// method describes a single interface method.
+type method struct {
+	Name   string
+	RPC    string // JSON-RPC method name
+	Args   []arg
+	Result string // Go type of the result, empty for error-only methods
+}
+
+type arg struct {
+	Name  string // argument name
+	Field string // params struct field name
+	Type  string // Go type
+}
+
+// Params returns the Go type of the params object.
+func (m method) Params() string {
+	if len(m.Args) == 0 {
+		return "struct{}"
+	}
+
+	var b strings.Builder
+	b.WriteString("struct {\n")
+	for _, a := range m.Args {
+		fmt.Fprintf(&b, "%v %v `json:%q`\n", a.Field, a.Type, a.Name)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+func parseService(file, name, prefix string) (*service, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var iface *ast.InterfaceType
+	ast.Inspect(f, func(n ast.Node) bool {
+		if ts, ok := n.(*ast.TypeSpec); ok && ts.Name.Name == name {
+			iface, _ = ts.Type.(*ast.InterfaceType)
+		}
+		return iface == nil
+	})
+	if iface == nil {
+		return nil, fmt.Errorf("interface %v not found in %v", name, file)
+	}
+
+	if prefix == "" {
+		prefix = lowerFirst(name)
+	}
+	svc := &service{Package: f.Name.Name, Name: name, Prefix: prefix}
+	used := map[string]bool{}
+	typeString := func(e ast.Expr) string {
+		ast.Inspect(e, func(n ast.Node) bool {
+			if sel, ok := n.(*ast.SelectorExpr); ok {
+				if id, ok := sel.X.(*ast.Ident); ok {
+					used[id.Name] = true
+				}
+			}
+			return true
+		})
+		var b bytes.Buffer
+		printer.Fprint(&b, fset, e)
+		return b.String()
+	}
+
+	for _, field := range iface.Methods.List {
+		ft, ok := field.Type.(*ast.FuncType)
+		if !ok || len(field.Names) == 0 {
+			return nil, fmt.Errorf("%v: embedded interfaces are not supported", fset.Position(field.Pos()))
+		}
+		m := method{Name: field.Names[0].Name, RPC: svc.Prefix + "." + lowerFirst(field.Names[0].Name)}
+		pos := fset.Position(field.Pos())
+
+		params := ft.Params.List
+		if len(params) == 0 || typeString(params[0].Type) != "context.Context" {
+			return nil, fmt.Errorf("%v: first argument of %v should be context.Context", pos, m.Name)
+		}
+		if len(params[0].Names) > 1 {
+			params = append([]*ast.Field{{Names: params[0].Names[1:], Type: params[0].Type}}, params[1:]...)
+		} else {
+			params = params[1:]
+		}
+		for _, p := range params {
+			if _, ok := p.Type.(*ast.Ellipsis); ok {
+				return nil, fmt.Errorf("%v: variadic arguments are not supported", pos)
+			}
+			typ := typeString(p.Type)
+			if len(p.Names) == 0 {
+				name := "arg" + strconv.Itoa(len(m.Args)+1)
+				m.Args = append(m.Args, arg{Name: name, Field: upperFirst(name), Type: typ})
+			}
+			for _, n := range p.Names {
+				m.Args = append(m.Args, arg{Name: n.Name, Field: upperFirst(n.Name), Type: typ})
+			}
+		}
+
+		var results []ast.Expr
+		if ft.Results != nil {
+			for _, r := range ft.Results.List {
+				for i := 0; i < len(r.Names) || i == 0; i++ {
+					results = append(results, r.Type)
+				}
+			}
+		}
+		switch {
+		case len(results) == 1 && typeString(results[0]) == "error":
+		case len(results) == 2 && typeString(results[1]) == "error":
+			m.Result = typeString(results[0])
+		default:
+			return nil, fmt.Errorf("%v: %v should return (R, error) or error", pos, m.Name)
+		}
+
+		svc.Methods = append(svc.Methods, m)
+	}
+
+	for _, imp := range f.Imports {
+		path, _ := strconv.Unquote(imp.Path.Value)
+		name := filepath.Base(path)
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		if used[name] && path != "context" && path != jsonrpcPath {
+			spec := imp.Path.Value
+			if imp.Name != nil {
+				spec = imp.Name.Name + " " + spec
+			}
+			svc.Imports = append(svc.Imports, spec)
+		}
+	}
+
+	return svc, nil
+}
+
+func generate(svc *service) ([]byte, error) {
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, svc); err != nil {
+		return nil, err
+	}
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %v\n%s", err, b.Bytes())
+	}
+
+	return src, nil
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	r := []rune(s)
+	r[0] = unicode.ToLower(r[0])
+	return string(r)
+}
+
+func upperFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	r := []rune(s)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+var tmpl = template.Must(template.New("").Parse(`// Code generated by jsonrpc-gen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+
+	"github.com/neptulon/jsonrpc"
+{{- range .Imports}}
+	{{.}}
+{{- end}}
+)
+
+// {{.Name}}Client is a JSON-RPC client implementing {{.Name}}.
+type {{.Name}}Client struct {
+	caller jsonrpc.Caller
+}
+
+var _ {{.Name}} = (*{{.Name}}Client)(nil)
+
+// New{{.Name}}Client creates a {{.Name}} client making calls through given JSON-RPC connection.
+func New{{.Name}}Client(c jsonrpc.Caller) *{{.Name}}Client {
+	return &{{.Name}}Client{caller: c}
+}
+{{range .Methods}}
+// {{.Name}} calls {{.RPC}} method.
+func (c *{{$.Name}}Client) {{.Name}}(ctx context.Context{{range .Args}}, {{.Name}} {{.Type}}{{end}}) {{if .Result}}({{.Result}}, error){{else}}error{{end}} {
+	params := {{.Params}}{ {{- range $i, $a := .Args}}{{if $i}}, {{end}}{{$a.Name}}{{end -}} }
+{{- if .Result}}
+	var result {{.Result}}
+	err := c.caller.Call(ctx, "{{.RPC}}", params, &result)
+	return result, err
+{{- else}}
+	return c.caller.Call(ctx, "{{.RPC}}", params, nil)
+{{- end}}
+}
+{{end}}
+// Register{{.Name}} registers all the methods of given {{.Name}} implementation as request routes.
+func Register{{.Name}}(r *jsonrpc.Router, svc {{.Name}}) error {
+{{- range .Methods}}
+	if err := r.TypedRequest("{{.RPC}}", func(ctx *jsonrpc.ReqCtx, params {{.Params}}) ({{if .Result}}{{.Result}}{{else}}struct{}{{end}}, error) {
+{{- if .Result}}
+		return svc.{{.Name}}(ctx.Context(){{range .Args}}, params.{{.Field}}{{end}})
+{{- else}}
+		return struct{}{}, svc.{{.Name}}(ctx.Context(){{range .Args}}, params.{{.Field}}{{end}})
+{{- end}}
+	}); err != nil {
+		return err
+	}
+{{- end}}
+
+	return nil
+}
+`))
@@ -0,0 +1,225 @@
+// Command jsonrpc is a command-line JSON-RPC client for Neptulon servers.
+//
+// It sends a single request (or notification with -n flag) given on the command line and prints the response result:
+//
+//	jsonrpc -addr 127.0.0.1:3000 users.get '{"id":"1"}'
+//	echo '{"id":"1"}' | jsonrpc users.get -
+//	jsonrpc -tls -addr rpc.example.com:443 users.get '{"id":"1"}'
+//
+// Without a method argument, it reads one message per line from stdin, in the form of:
+//
+//	method [params]          sends a request and prints the response
+//	notify method [params]   sends a notification
+//
+// With -i flag, the same commands can be typed in an interactive prompt. Notifications sent by the server are printed as they arrive.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/neptulon/jsonrpc"
+)
+
+var (
+	addr        = flag.String("addr", "127.0.0.1:3000", "network address of the server")
+	useTLS      = flag.Bool("tls", false, "connect with TLS, verifying the server certificate with the system root CAs unless -ca is given")
+	ca          = flag.String("ca", "", "CA certificate file (PEM) to verify the server certificate with")
+	cert        = flag.String("cert", "", "client certificate file (PEM) for TLS client authentication")
+	key         = flag.String("key", "", "client certificate private key file (PEM)")
+	notify      = flag.Bool("n", false, "send a notification instead of a request")
+	interactive = flag.Bool("i", false, "start an interactive prompt")
+	timeout     = flag.Duration("timeout", 10*time.Second, "time to wait for each response")
+	debug       = flag.Bool("debug", false, "log all connection activity")
+)
+
+// out serializes writes to stdout from the prompt and the incoming notifications.
+var out struct {
+	sync.Mutex
+	w io.Writer
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("jsonrpc: ")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: jsonrpc [flags] [method [params | -]]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	out.w = os.Stdout
+
+	c, err := connect()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+
+	if flag.NArg() > 0 {
+		params := ""
+		if flag.NArg() > 1 {
+			params = flag.Arg(1)
+		}
+		if params == "-" {
+			b, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				log.Fatal(err)
+			}
+			params = string(b)
+		}
+		if err := send(c, *notify, flag.Arg(0), params); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if err := repl(c, os.Stdin, *interactive); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func connect() (*jsonrpc.Client, error) {
+	c := jsonrpc.NewClient(nil, nil)
+
+	if *useTLS || *ca != "" || *cert != "" {
+		caPEM, err := readFile(*ca)
+		if err != nil {
+			return nil, err
+		}
+		certPEM, err := readFile(*cert)
+		if err != nil {
+			return nil, err
+		}
+		keyPEM, err := readFile(*key)
+		if err != nil {
+			return nil, err
+		}
+		c.UseTLS(caPEM, certPEM, keyPEM)
+	}
+
+	c.NotMiddleware(func(ctx *jsonrpc.NotCtx) error {
+		var params json.RawMessage
+		if err := ctx.Params(&params); err != nil {
+			return err
+		}
+		printf("<- %v %s\n", ctx.Method(), params)
+		ctx.Handled()
+		return ctx.Next()
+	})
+
+	if err := c.Connect(*addr, *debug); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func readFile(name string) ([]byte, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	return os.ReadFile(name)
+}
+
+// repl reads and executes one command per line until EOF or "exit" command.
+func repl(c *jsonrpc.Client, r io.Reader, prompt bool) error {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for {
+		if prompt {
+			printf("> ")
+		}
+		if !s.Scan() {
+			return s.Err()
+		}
+
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == "exit" || line == "quit" {
+			return nil
+		}
+
+		notification := false
+		if cmd, rest := split(line); cmd == "notify" {
+			notification, line = true, rest
+		}
+		method, params := split(line)
+		if err := send(c, notification, method, params); err != nil {
+			if !prompt {
+				return err
+			}
+			printf("error: %v\n", err)
+		}
+	}
+}
+
+// split splits the first word off the line.
+func split(line string) (string, string) {
+	if i := strings.IndexAny(line, " \t"); i != -1 {
+		return line[:i], strings.TrimSpace(line[i+1:])
+	}
+
+	return line, ""
+}
+
+// send sends a request or a notification with given JSON encoded params, and prints the response result (if any).
+func send(c *jsonrpc.Client, notification bool, method, params string) error {
+	if method == "" {
+		return errors.New("method name is required")
+	}
+
+	var p interface{}
+	if params = strings.TrimSpace(params); params != "" {
+		if !json.Valid([]byte(params)) {
+			return fmt.Errorf("params is not valid JSON: %v", params)
+		}
+		p = json.RawMessage(params)
+	}
+
+	if notification {
+		return c.SendNotification(method, p)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	var res json.RawMessage
+	if err := c.Call(ctx, method, p, &res); err != nil {
+		if resErr, ok := err.(*jsonrpc.ResError); ok {
+			data, _ := json.Marshal(resErr)
+			return fmt.Errorf("error response: %s", data)
+		}
+		return err
+	}
+
+	var b bytes.Buffer
+	if len(res) == 0 {
+		res = json.RawMessage("null")
+	}
+	if err := json.Indent(&b, res, "", "  "); err != nil {
+		b.Reset()
+		b.Write(res)
+	}
+	printf("%s\n", b.Bytes())
+	return nil
+}
+
+func printf(format string, args ...interface{}) {
+	out.Lock()
+	defer out.Unlock()
+	fmt.Fprintf(out.w, format, args...)
+}
@@ -0,0 +1,175 @@
+package jsonrpc
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/ugorji/go/codec"
+)
+
+// Codec encodes and decodes JSON-RPC messages. All codecs share the JSON-RPC envelope semantics (id, method, params, result, error)
+// and honor `json` struct tags, so the same types can be used as params and results regardless of the encoding on the wire.
+type Codec interface {
+	// Name is the unique name of the encoding (i.e. "json", "msgpack").
+	Name() string
+
+	// Binary tells whether the encoded messages are binary data rather than UTF-8 text, for transports that distinguish the two (i.e. WebSocket frames).
+	Binary() bool
+
+	// Marshal encodes given value.
+	Marshal(v interface{}) ([]byte, error)
+
+	// Unmarshal decodes given data into v. Object should be passed by reference.
+	Unmarshal(data []byte, v interface{}) error
+
+	// UnmarshalFields decodes a top-level object into its members, leaving member values encoded.
+	UnmarshalFields(data []byte) (map[string][]byte, error)
+}
+
+var (
+	// JSON is the default codec, encoding messages as JSON text.
+	JSON Codec = jsonCodec{}
+
+	// MsgPack encodes messages in MessagePack binary format.
+	MsgPack Codec = binaryCodec{name: "msgpack", handle: newMsgpackHandle()}
+
+	// CBOR encodes messages in Concise Binary Object Representation (RFC 7049) format.
+	CBOR Codec = binaryCodec{name: "cbor", handle: newCborHandle()}
+)
+
+// Schema-less values are decoded into map[string]interface{} as encoding/json would do.
+var mapType = reflect.TypeOf(map[string]interface{}(nil))
+
+func newMsgpackHandle() *codec.MsgpackHandle {
+	h := codec.MsgpackHandle{WriteExt: true}
+	h.MapType = mapType
+	h.RawToString = true
+	h.Raw = true
+	return &h
+}
+
+func newCborHandle() *codec.CborHandle {
+	h := codec.CborHandle{}
+	h.MapType = mapType
+	h.Raw = true
+	return &h
+}
+
+// rawCodec is implemented by the codecs which can write already encoded values as is, see RawMessage.
+type rawCodec interface {
+	raw(data []byte) interface{}
+}
+
+// rawValue converts a RawMessage into a value that given codec can encode, or returns v as is if it is not a RawMessage.
+func rawValue(c Codec, v interface{}) (interface{}, error) {
+	raw, ok := v.(RawMessage)
+	if !ok {
+		return v, nil
+	}
+	if raw.Data == nil {
+		return nil, nil
+	}
+
+	from := raw.Codec
+	if from == nil {
+		from = c
+	}
+	if rc, ok := c.(rawCodec); ok && from.Name() == c.Name() {
+		return rc.raw(raw.Data), nil
+	}
+
+	var val interface{}
+	if err := from.Unmarshal(raw.Data, &val); err != nil {
+		return nil, err
+	}
+
+	return val, nil
+}
+
+// readRaw stores given encoded value into v if it is a *RawMessage, and tells whether it was.
+func readRaw(c Codec, data []byte, v interface{}) bool {
+	raw, ok := v.(*RawMessage)
+	if ok {
+		*raw = RawMessage{Data: data, Codec: c}
+	}
+
+	return ok
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Binary() bool {
+	return false
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) raw(data []byte) interface{} {
+	return json.RawMessage(data)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) UnmarshalFields(data []byte) (map[string][]byte, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string][]byte, len(raw))
+	for k, v := range raw {
+		fields[k] = v
+	}
+
+	return fields, nil
+}
+
+// binaryCodec is a codec backed by one of the binary encodings of ugorji/go/codec.
+type binaryCodec struct {
+	name   string
+	handle codec.Handle
+}
+
+func (c binaryCodec) Name() string {
+	return c.name
+}
+
+func (binaryCodec) Binary() bool {
+	return true
+}
+
+func (c binaryCodec) Marshal(v interface{}) ([]byte, error) {
+	var data []byte
+	err := codec.NewEncoderBytes(&data, c.handle).Encode(v)
+	return data, err
+}
+
+func (binaryCodec) raw(data []byte) interface{} {
+	return codec.Raw(data)
+}
+
+func (c binaryCodec) Unmarshal(data []byte, v interface{}) error {
+	return codec.NewDecoderBytes(data, c.handle).Decode(v)
+}
+
+func (c binaryCodec) UnmarshalFields(data []byte) (map[string][]byte, error) {
+	var raw map[string]codec.Raw
+	if err := c.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string][]byte, len(raw))
+	for k, v := range raw {
+		fields[k] = v
+	}
+
+	return fields, nil
+}
@@ -0,0 +1,94 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// ExtCompression is the name of the protocol extension for per message gzip compression.
+// Compressed messages are detected by the gzip header, which can never start a JSON, MessagePack, or CBOR encoded JSON-RPC message.
+const ExtCompression = "compression"
+
+// DefaultCompressionThreshold is the message size (in bytes) above which outgoing messages are compressed, unless set otherwise with UseCompression.
+const DefaultCompressionThreshold = 32 * 1024
+
+var gzipHeader = []byte{0x1f, 0x8b}
+
+// UseCompression sets the message size (in bytes) above which outgoing messages are compressed with gzip.
+// Compression only applies to connections with ExtCompression enabled, either by UseExtensions or through negotiation.
+// Incoming compressed messages are decompressed on such connections, regardless of this setting.
+func (mw *Middleware) UseCompression(threshold int) {
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+
+	mw.compressionThreshold = threshold
+}
+
+// compress compresses the given outgoing message if it is large enough and the connection denoted by the connection ID supports compression.
+func (mw *Middleware) compress(connID string, msg []byte) ([]byte, error) {
+	mw.mutex.RLock()
+	threshold := mw.compressionThreshold
+	mw.mutex.RUnlock()
+
+	if threshold <= 0 {
+		threshold = DefaultCompressionThreshold
+	}
+	if len(msg) <= threshold || !mw.HasExtension(connID, ExtCompression) {
+		return msg, nil
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(msg); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UseDecompressionLimit sets the maximum size (in bytes) of an incoming message after decompression. Larger messages are dropped with an error.
+// Default limit is DefaultMaxMessageSize.
+func (mw *Middleware) UseDecompressionLimit(limit int) {
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+
+	mw.decompressionLimit = limit
+}
+
+// decompress decompresses the given incoming message if it is compressed and the connection denoted by the connection ID supports compression,
+// or returns it as is otherwise.
+func (mw *Middleware) decompress(connID string, msg []byte) ([]byte, error) {
+	if !bytes.HasPrefix(msg, gzipHeader) || !mw.HasExtension(connID, ExtCompression) {
+		return msg, nil
+	}
+
+	mw.mutex.RLock()
+	limit := mw.decompressionLimit
+	mw.mutex.RUnlock()
+
+	if limit <= 0 {
+		limit = DefaultMaxMessageSize
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(msg))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// read one byte past the limit to tell a message of exactly the limit size from a larger one
+	data, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > limit {
+		return nil, fmt.Errorf("decompressed message exceeds the maximum message size of %v bytes", limit)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,24 @@
+package jsonrpc
+
+import "github.com/neptulon/cmap"
+
+// Conn is a full-duplex transport connection carrying raw JSON-RPC messages.
+// Middleware, Sender, and context objects depend only on this interface so any transport can be plugged in.
+// Neptulon, WebSocket, byte stream, and in-memory pipe implementations are included in this package.
+type Conn interface {
+	// ConnID is a unique connection ID.
+	ConnID() string
+
+	// Session is a thread-safe data store for storing arbitrary data for this connection session.
+	Session() *cmap.CMap
+
+	// Send writes a single message to the connection. It is safe to call Send from multiple goroutines.
+	Send(msg []byte) error
+
+	// Receive blocks until the next message is read from the connection.
+	// Returns io.EOF once the connection is closed.
+	Receive() ([]byte, error)
+
+	// Close closes the connection.
+	Close() error
+}
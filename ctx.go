@@ -1,11 +1,10 @@
 package jsonrpc
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 
 	"github.com/neptulon/cmap"
-	"github.com/neptulon/neptulon"
 )
 
 /*
@@ -17,27 +16,31 @@ type ReqCtx struct {
 	Res    interface{} // Response to be returned.
 	Err    *ResError   // Error to be returned.
 	Client *Client     // Client connection.
+	Peer   *Peer       // The other end of the connection, which can be called back.
 
-	id     string          // message ID
-	method string          // called method
-	params json.RawMessage // request parameters
+	id     string // message ID
+	method string // called method
+	route  string // called method relative to the prefix of the mounted router handling the request, see Router.Mount
+	params []byte // request parameters
+
+	captures map[string]string // method name segments captured by the pattern routes, see Router.Request
+	handled  bool              // whether a route has claimed the request, see Middleware.UnhandledRequest
+	raw      []byte            // raw message, see Middleware.UseDeadLetter
 
 	mw      []func(ctx *ReqCtx) error
 	mwIndex int
 	session *cmap.CMap
+	codec   Codec
+	context context.Context
+	meta    map[string]string
+	drainer *drainer // drainer of the middleware stack the request is received by, see Hold
 }
 
-func newReqCtx(id, method string, params json.RawMessage, client *neptulon.Client, mw []func(ctx *ReqCtx) error, session *cmap.CMap) *ReqCtx {
+func newReqCtx(id, method string, params []byte, client *Client, mw []func(ctx *ReqCtx) error, session *cmap.CMap, codec Codec) *ReqCtx {
 	// append the last middleware to stack, which will write the response to connection, if any
-	mw = append(mw, func(ctx *ReqCtx) error {
-		if ctx.Res != nil || ctx.Err != nil {
-			return ctx.Client.SendResponse(ctx.id, ctx.Res, ctx.Err)
-		}
-
-		return nil
-	})
+	mw = append(mw, writeResponse)
 
-	return &ReqCtx{Client: UseClient(client), id: id, method: method, params: params, mw: mw, session: session}
+	return &ReqCtx{Client: client, id: id, method: method, route: method, params: params, mw: mw, session: session, codec: codec}
 }
 
 // Session is a data store for storing arbitrary data within this context to communicate with other middleware handling this message.
@@ -45,11 +48,55 @@ func (ctx *ReqCtx) Session() *cmap.CMap {
 	return ctx.session
 }
 
+// Method returns the name of the called method.
+func (ctx *ReqCtx) Method() string {
+	return ctx.method
+}
+
+// Meta returns the out-of-band metadata sent along with the request envelope (if any).
+func (ctx *ReqCtx) Meta() map[string]string {
+	return ctx.meta
+}
+
+// Capture returns the method name segment captured with given name by the pattern route handling the request (i.e. "room" for "events.{room}.post"),
+// or the segments matched by a trailing wildcard for "*". Returns an empty string if there is no such capture.
+func (ctx *ReqCtx) Capture(name string) string {
+	return ctx.captures[name]
+}
+
+// Context returns the context.Context of the request, which carries values attached by the middleware (if any),
+// and is canceled when the connection that the request was received from is closed.
+func (ctx *ReqCtx) Context() context.Context {
+	if ctx.context == nil {
+		return context.Background()
+	}
+
+	return ctx.context
+}
+
+// SetContext replaces the context.Context of the request, i.e. to attach values for the following middleware.
+func (ctx *ReqCtx) SetContext(c context.Context) {
+	ctx.context = c
+}
+
+// Hold keeps a graceful shutdown waiting until release is called, for handlers responding to the request asynchronously
+// (i.e. calling ctx.Respond from a goroutine after the handler has returned). Should be called before the handler returns. Calling release more than once is a no-op.
+func (ctx *ReqCtx) Hold() (release func()) {
+	if ctx.drainer == nil {
+		return func() {}
+	}
+
+	return ctx.drainer.hold()
+}
+
 // Params reads request parameters into given object.
-// Object should be passed by reference.
+// Object should be passed by reference. Params are left encoded if the object is a *RawMessage.
 func (ctx *ReqCtx) Params(v interface{}) error {
+	if readRaw(ctx.codec, ctx.params, v) {
+		return nil
+	}
 	if ctx.params != nil {
-		if err := json.Unmarshal(ctx.params, v); err != nil {
+		if err := ctx.codec.Unmarshal(ctx.params, v); err != nil {
 			return fmt.Errorf("cannot deserialize request params: %v", err)
 		}
 	}
@@ -68,20 +115,72 @@ func (ctx *ReqCtx) Next() error {
 	return nil
 }
 
+// nest runs given middleware stack with given route, and then resumes the middleware stack of the context as it was.
+// Used for the middleware stacks of mounted routers and routes.
+func (ctx *ReqCtx) nest(mw []func(ctx *ReqCtx) error, route string) error {
+	parent, parentIndex, parentRoute := ctx.mw, ctx.mwIndex, ctx.route
+	ctx.mw = append(mw[:len(mw):len(mw)], func(ctx *ReqCtx) error {
+		ctx.mw, ctx.mwIndex, ctx.route = parent, parentIndex, parentRoute
+		return ctx.Next()
+	})
+	ctx.mwIndex, ctx.route = 0, route
+	return ctx.Next()
+}
+
+// Respond skips the rest of the middleware stack (including the stacks of the enclosing routers, if any) and writes the response right away.
+// Useful for middleware rejecting requests, i.e. by setting ctx.Err before calling Respond instead of Next.
+func (ctx *ReqCtx) Respond() error {
+	ctx.mwIndex = len(ctx.mw)
+	return writeResponse(ctx)
+}
+
+// writeResponse is the last middleware of all request middleware stacks, which writes the response to connection, if any.
+func writeResponse(ctx *ReqCtx) error {
+	if ctx.Res != nil || ctx.Err != nil {
+		return ctx.Client.SendResponse(ctx.id, ctx.Res, ctx.Err)
+	}
+
+	return nil
+}
+
+// mergeCaptures adds the segments captured by a pattern route to the ones captured by the enclosing routers (if any), without modifying either.
+func mergeCaptures(captures, add map[string]string) map[string]string {
+	if len(add) == 0 {
+		return captures
+	}
+
+	merged := make(map[string]string, len(captures)+len(add))
+	for k, v := range captures {
+		merged[k] = v
+	}
+	for k, v := range add {
+		merged[k] = v
+	}
+	return merged
+}
+
 // NotCtx encapsulates connection and notification objects.
 type NotCtx struct {
 	Client *Client
+	Peer   *Peer // The other end of the connection, which can be called back.
 
-	method string          // called method
-	params json.RawMessage // notification parameters
+	method string // called method
+	route  string // called method relative to the prefix of the mounted router handling the notification, see Router.Mount
+	params []byte // notification parameters
+
+	captures map[string]string // method name segments captured by the pattern routes, see Router.Request
+	handled  bool              // whether a route or listener has claimed the notification, see Middleware.UnhandledNotification
+	raw      []byte            // raw message, see Middleware.UseDeadLetter
 
 	mw      []func(ctx *NotCtx) error
 	mwIndex int
 	session *cmap.CMap
+	codec   Codec
+	meta    map[string]string
 }
 
-func newNotCtx(method string, params json.RawMessage, client *neptulon.Client, mw []func(ctx *NotCtx) error, session *cmap.CMap) *NotCtx {
-	return &NotCtx{Client: UseClient(client), method: method, params: params, mw: mw, session: session}
+func newNotCtx(method string, params []byte, client *Client, mw []func(ctx *NotCtx) error, session *cmap.CMap, codec Codec) *NotCtx {
+	return &NotCtx{Client: client, method: method, route: method, params: params, mw: mw, session: session, codec: codec}
 }
 
 // Session is a data store for storing arbitrary data within this context to communicate with other middleware handling this message.
@@ -89,11 +188,29 @@ func (ctx *NotCtx) Session() *cmap.CMap {
 	return ctx.session
 }
 
+// Method returns the name of the called method.
+func (ctx *NotCtx) Method() string {
+	return ctx.method
+}
+
+// Meta returns the out-of-band metadata sent along with the notification envelope (if any).
+func (ctx *NotCtx) Meta() map[string]string {
+	return ctx.meta
+}
+
+// Capture returns the method name segment captured with given name by the pattern route handling the notification. See ReqCtx.Capture.
+func (ctx *NotCtx) Capture(name string) string {
+	return ctx.captures[name]
+}
+
 // Params reads response parameters into given object.
-// Object should be passed by reference.
+// Object should be passed by reference. Params are left encoded if the object is a *RawMessage.
 func (ctx *NotCtx) Params(v interface{}) error {
+	if readRaw(ctx.codec, ctx.params, v) {
+		return nil
+	}
 	if ctx.params != nil {
-		if err := json.Unmarshal(ctx.params, v); err != nil {
+		if err := ctx.codec.Unmarshal(ctx.params, v); err != nil {
 			return fmt.Errorf("cannot deserialize notification params: %v", err)
 		}
 	}
@@ -101,6 +218,12 @@ func (ctx *NotCtx) Params(v interface{}) error {
 	return nil
 }
 
+// Handled marks the notification as claimed, for middleware consuming notifications outside of a router,
+// so that it is not passed to the unhandled notification hooks (and the dead letter sink) at the end of the stack. See Middleware.UnhandledNotification.
+func (ctx *NotCtx) Handled() {
+	ctx.handled = true
+}
+
 // Next executes the next middleware in the middleware stack.
 func (ctx *NotCtx) Next() error {
 	ctx.mwIndex++
@@ -112,22 +235,45 @@ func (ctx *NotCtx) Next() error {
 	return nil
 }
 
+// nest runs given middleware stack with given route, and then resumes the middleware stack of the context as it was. See ReqCtx.nest.
+func (ctx *NotCtx) nest(mw []func(ctx *NotCtx) error, route string) error {
+	parent, parentIndex, parentRoute := ctx.mw, ctx.mwIndex, ctx.route
+	ctx.mw = append(mw[:len(mw):len(mw)], func(ctx *NotCtx) error {
+		ctx.mw, ctx.mwIndex, ctx.route = parent, parentIndex, parentRoute
+		return ctx.Next()
+	})
+	ctx.mwIndex, ctx.route = 0, route
+	return ctx.Next()
+}
+
 // ResCtx encapsulates connection and response objects.
 type ResCtx struct {
+	Err    *ResError // Error object of the response, if the request has failed.
 	Client *Client
+	Peer   *Peer // The other end of the connection, which can be called back.
 
-	id     string          // message ID
-	result json.RawMessage // result parameters
+	id     string // message ID
+	result []byte // result parameters
 
-	err *resError // response error (if any)
+	handled bool   // whether a request was waiting for the response, see Middleware.UnhandledResponse
+	raw     []byte // raw message, see Middleware.UseDeadLetter
 
 	mw      []func(ctx *ResCtx) error
 	mwIndex int
 	session *cmap.CMap
+	codec   Codec
 }
 
-func newResCtx(id string, result json.RawMessage, client *neptulon.Client, mw []func(ctx *ResCtx) error, session *cmap.CMap) *ResCtx {
-	return &ResCtx{Client: UseClient(client), id: id, result: result, mw: mw, session: session}
+func newResCtx(id string, result []byte, err *resError, client *Client, mw []func(ctx *ResCtx) error, session *cmap.CMap, codec Codec) *ResCtx {
+	ctx := ResCtx{Client: client, id: id, result: result, mw: mw, session: session, codec: codec}
+	if err != nil {
+		ctx.Err = &ResError{Code: err.Code, Message: err.Message}
+		if err.Data != nil {
+			codec.Unmarshal(err.Data, &ctx.Err.Data)
+		}
+	}
+
+	return &ctx
 }
 
 // Session is a data store for storing arbitrary data within this context to communicate with other middleware handling this message.
@@ -136,10 +282,13 @@ func (ctx *ResCtx) Session() *cmap.CMap {
 }
 
 // Result reads response result data into given object.
-// Object should be passed by reference.
+// Object should be passed by reference. Result is left encoded if the object is a *RawMessage.
 func (ctx *ResCtx) Result(v interface{}) error {
+	if readRaw(ctx.codec, ctx.result, v) {
+		return nil
+	}
 	if ctx.result != nil {
-		if err := json.Unmarshal(ctx.result, v); err != nil {
+		if err := ctx.codec.Unmarshal(ctx.result, v); err != nil {
 			return fmt.Errorf("cannot deserialize response result: %v", err)
 		}
 	}
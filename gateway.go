@@ -0,0 +1,179 @@
+package jsonrpc
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Backend is a JSON-RPC connection to forward messages to. Client is a Backend.
+type Backend interface {
+	MiddlewareHandler
+	Caller
+	SendNotificationContext(ctx context.Context, method string, params interface{}) error
+}
+
+// Gateway is a reverse proxy middleware forwarding requests and notifications to backend servers, chosen by method name prefix.
+// Forwarded requests are sent with new IDs generated by the backend connection so concurrent callers never collide,
+// and the responses are returned to the originating connection with the original request IDs.
+// Params and results are forwarded without being decoded, see RawMessage.
+// Notifications sent by a backend are relayed to all the connections that have sent any message routed to that backend.
+// Messages not matching any route are passed to the next middleware.
+type Gateway struct {
+	Timeout time.Duration // Time to wait for a backend response before answering with an error. Zero means no timeout.
+
+	mutex  sync.RWMutex
+	routes map[string]*gatewayRoute // method name prefix -> backend route
+}
+
+type gatewayRoute struct {
+	backend Backend
+	mutex   sync.Mutex
+	subs    map[string]*Client // conn ID -> open connection to relay backend notifications to
+}
+
+// NewGateway creates a JSON-RPC gateway instance and registers it as a Neptulon JSON-RPC middleware.
+func NewGateway(m RouteHandler) (*Gateway, error) {
+	if m == nil {
+		return nil, errors.New("given JSON-RPC Middleware instance is nil")
+	}
+
+	g := Gateway{routes: make(map[string]*gatewayRoute)}
+	m.ReqMiddleware(g.reqMiddleware)
+	m.NotMiddleware(g.notMiddleware)
+	return &g, nil
+}
+
+// Route forwards all the methods starting with given prefix (i.e. "billing." or "billing.*") to the backend.
+// The longest matching prefix wins if multiple routes match a method.
+func (g *Gateway) Route(prefix string, backend Backend) {
+	r := &gatewayRoute{backend: backend, subs: make(map[string]*Client)}
+	backend.NotMiddleware(r.relay)
+
+	g.mutex.Lock()
+	g.routes[strings.TrimSuffix(prefix, "*")] = r
+	g.mutex.Unlock()
+}
+
+// route finds the route with the longest prefix matching the method.
+func (g *Gateway) route(method string) *gatewayRoute {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	var match *gatewayRoute
+	var matchLen = -1
+	for prefix, r := range g.routes {
+		if len(prefix) > matchLen && strings.HasPrefix(method, prefix) {
+			match, matchLen = r, len(prefix)
+		}
+	}
+
+	return match
+}
+
+func (g *Gateway) reqMiddleware(ctx *ReqCtx) error {
+	r := g.route(ctx.method)
+	if r == nil {
+		return ctx.Next()
+	}
+
+	var params RawMessage
+	ctx.Params(&params)
+	r.subscribe(ctx.Client, ctx.Peer)
+
+	// wait for the backend response asynchronously so the incoming connection can keep receiving messages,
+	// while keeping a graceful shutdown waiting for the response to be sent
+	release := ctx.Hold()
+	go func() {
+		defer release()
+
+		// relay the incoming metadata as is, unless a middleware has set the metadata to propagate (i.e. tracing)
+		parent := ctx.Context()
+		if MetaFromContext(parent) == nil && ctx.meta != nil {
+			parent = ContextWithMeta(parent, ctx.meta)
+		}
+
+		c, cancel := context.WithCancel(parent)
+		if g.Timeout > 0 {
+			cancel()
+			c, cancel = context.WithTimeout(parent, g.Timeout)
+		}
+		defer cancel()
+
+		var res RawMessage
+		var resErr *ResError
+		if err := r.backend.Call(c, ctx.method, params, &res); err != nil {
+			var ok bool
+			if resErr, ok = err.(*ResError); !ok {
+				resErr = &ResError{Code: -32603, Message: "Internal error", Data: "backend unavailable: " + err.Error()}
+			}
+		}
+
+		if err := ctx.Client.SendResponse(ctx.id, res, resErr); err != nil {
+			log.Printf("jsonrpc: gateway error sending response for %v to %v: %v", ctx.method, ctx.Client.ConnID(), err)
+		}
+	}()
+
+	return nil
+}
+
+func (g *Gateway) notMiddleware(ctx *NotCtx) error {
+	r := g.route(ctx.method)
+	if r == nil {
+		return ctx.Next()
+	}
+
+	var params RawMessage
+	ctx.Params(&params)
+	r.subscribe(ctx.Client, ctx.Peer)
+
+	return r.backend.SendNotificationContext(ContextWithMeta(context.Background(), ctx.meta), ctx.method, params)
+}
+
+// subscribe adds the connection to the relay list of the route, until the connection is closed.
+func (r *gatewayRoute) subscribe(c *Client, p *Peer) {
+	connID := c.ConnID()
+
+	r.mutex.Lock()
+	_, ok := r.subs[connID]
+	r.subs[connID] = c
+	r.mutex.Unlock()
+
+	if !ok && p != nil {
+		go func() {
+			<-p.Context().Done()
+			r.mutex.Lock()
+			delete(r.subs, connID)
+			r.mutex.Unlock()
+		}()
+	}
+}
+
+// relay is a backend notification middleware which relays incoming notifications to the subscribers.
+// Subscribers that cannot be written to anymore (i.e. disconnected ones) are removed.
+func (r *gatewayRoute) relay(ctx *NotCtx) error {
+	var params RawMessage
+	ctx.Params(&params)
+
+	r.mutex.Lock()
+	subs := make([]*Client, 0, len(r.subs))
+	for _, c := range r.subs {
+		subs = append(subs, c)
+	}
+	r.mutex.Unlock()
+
+	meta := ContextWithMeta(context.Background(), ctx.meta)
+	for _, c := range subs {
+		if err := c.SendNotificationContext(meta, ctx.method, params); err != nil {
+			r.mutex.Lock()
+			delete(r.subs, c.ConnID())
+			r.mutex.Unlock()
+		}
+	}
+
+	ctx.handled = true
+	return ctx.Next()
+}
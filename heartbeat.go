@@ -0,0 +1,134 @@
+package jsonrpc
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// PingMethod is the method name of the heartbeat requests, which are answered by all middleware stacks automatically.
+const PingMethod = "rpc.ping"
+
+// DefaultHeartbeatMisses is the number of pings in a row allowed to be missed before a connection is closed, if not given.
+const DefaultHeartbeatMisses = 3
+
+// deadliner is implemented by connections with read/write deadlines (i.e. Neptulon connections), which are extended with the heartbeat.
+type deadliner interface {
+	SetDeadline(seconds int)
+}
+
+// heartbeat is the state of a heartbeat loop of a Peer.
+type heartbeat struct {
+	seen    int64 // time of the last incoming message, in Unix nanoseconds
+	started int32 // 1 if the loop is started
+	stop    chan struct{}
+}
+
+// UseHeartbeat enables the heartbeat on all connections: a connection that has not sent any messages for an interval is sent an rpc.ping request,
+// and it is closed as dead if a given number of pings in a row are not answered within an interval. Error responses count as answers. Closing fires the disconnect handler of the transport, if any.
+// Read/write deadlines of the connections are extended to cover the missed pings, only on incoming messages and answered pings.
+// Connections are pinged once they are served, or once the first message is received from them for push based transports (i.e. Neptulon).
+// If misses is not positive, DefaultHeartbeatMisses is used.
+func (mw *Middleware) UseHeartbeat(interval time.Duration, misses int) {
+	if misses <= 0 {
+		misses = DefaultHeartbeatMisses
+	}
+
+	mw.mutex.Lock()
+	mw.heartbeatInterval, mw.heartbeatMisses = interval, misses
+	peers := make([]*Peer, 0, len(mw.peers))
+	for _, p := range mw.peers {
+		peers = append(peers, p)
+	}
+	mw.mutex.Unlock()
+
+	for _, p := range peers {
+		p.startHeartbeat()
+	}
+}
+
+// UseHeartbeat enables the heartbeat on the client connection and starts pinging right away, without waiting for the connection to be served.
+// See Middleware.UseHeartbeat for details.
+func (c *Client) UseHeartbeat(interval time.Duration, misses int) {
+	c.Middleware.UseHeartbeat(interval, misses)
+	c.Peer()
+}
+
+func (mw *Middleware) heartbeatSettings() (time.Duration, int) {
+	mw.mutex.RLock()
+	defer mw.mutex.RUnlock()
+
+	return mw.heartbeatInterval, mw.heartbeatMisses
+}
+
+// touch records an incoming message from the peer and extends the connection deadline.
+func (p *Peer) touch() {
+	atomic.StoreInt64(&p.heartbeat.seen, time.Now().UnixNano())
+	p.extendDeadline()
+}
+
+// startHeartbeat starts the heartbeat loop, if it is enabled and not started yet.
+func (p *Peer) startHeartbeat() {
+	interval, misses := p.mw.heartbeatSettings()
+	if interval <= 0 || !atomic.CompareAndSwapInt32(&p.heartbeat.started, 0, 1) {
+		return
+	}
+
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		missed := 0
+		for {
+			select {
+			case <-p.heartbeat.stop:
+				return
+			case <-t.C:
+			}
+
+			if time.Since(time.Unix(0, atomic.LoadInt64(&p.heartbeat.seen))) < interval {
+				missed = 0
+				continue // there was traffic, no need to ping
+			}
+
+			// any response proves the peer is alive, including the error responses of the peers that do not answer pings (i.e. method not found),
+			// so only timeouts and send errors count as missed pings
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			err := p.Call(ctx, PingMethod, nil, nil)
+			cancel()
+			if _, ok := err.(*ResError); ok || err == nil {
+				missed = 0
+				p.extendDeadline()
+				continue
+			}
+
+			// the peer is forgotten right away, as push based transports might not report the closed connection back
+			if missed++; missed >= misses {
+				p.Close()
+				p.mw.forgetConn(p.ConnID())
+				return
+			}
+		}
+	}()
+}
+
+// stopHeartbeat stops the heartbeat loop of a closed connection.
+func (p *Peer) stopHeartbeat() {
+	p.heartbeatStop.Do(func() { close(p.heartbeat.stop) })
+}
+
+// extendDeadline extends the read/write deadline of the connection (if any) to cover all the allowed missed pings.
+func (p *Peer) extendDeadline() {
+	d, ok := p.conn.(deadliner)
+	if !ok {
+		return
+	}
+
+	interval, misses := p.mw.heartbeatSettings()
+	if interval <= 0 {
+		return
+	}
+
+	seconds := int((interval*time.Duration(misses+1) + time.Second - 1) / time.Second)
+	d.SetDeadline(seconds)
+}
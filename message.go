@@ -1,20 +1,22 @@
 package jsonrpc
 
-import "encoding/json"
+import "fmt"
 
 // JSON-RPC 2.0 message types. Version field is ommited for brevity.
 
 // Request is a JSON-RPC request object.
 type Request struct {
-	ID     string      `json:"id"`
-	Method string      `json:"method"`
-	Params interface{} `json:"params,omitempty"`
+	ID     string            `json:"id"`
+	Method string            `json:"method"`
+	Params interface{}       `json:"params,omitempty"`
+	Meta   map[string]string `json:"meta,omitempty"` // Optional out-of-band metadata (i.e. W3C "traceparent"), which is not a part of JSON-RPC 2.0.
 }
 
 // Notification is a JSON-RPC notification object.
 type Notification struct {
-	Method string      `json:"method"`
-	Params interface{} `json:"params,omitempty"`
+	Method string            `json:"method"`
+	Params interface{}       `json:"params,omitempty"`
+	Meta   map[string]string `json:"meta,omitempty"` // Optional out-of-band metadata, same as Request.Meta.
 }
 
 // Response is a JSON-RPC response object.
@@ -31,19 +33,84 @@ type ResError struct {
 	Data    interface{} `json:"data,omitempty"`
 }
 
+// Error implements the error interface so *ResError can be returned from typed handlers.
+func (e *ResError) Error() string {
+	return fmt.Sprintf("jsonrpc: %v (%v)", e.Message, e.Code)
+}
+
+// RawMessage is a params or result value left encoded with the codec of the connection it was received from.
+// It is read with ctx.Params or ctx.Result into a *RawMessage, and can be sent as params or result to forward the value without decoding it.
+// Value is sent as is if the outgoing connection uses the same codec, and is converted otherwise.
+type RawMessage struct {
+	Data  []byte // encoded value
+	Codec Codec  // codec that the value is encoded with, nil means the codec of the outgoing connection
+}
+
 // message is a JSON-RPC request, response, or notification message.
 // This is used internally only to manage incoming messages.
 // We don't need this for outgoing messages as we always know their specific types.
+// Params, result, and error data are left encoded with the codec that the message was received with.
 type message struct {
-	ID     string          `json:"id,omitempty"`
-	Method string          `json:"method,omitempty"`
-	Params json.RawMessage `json:"params,omitempty"` // request params
-	Result json.RawMessage `json:"result,omitempty"` // response result
-	Error  *resError       `json:"error,omitempty"`  // response error
+	ID     string
+	Method string
+	Params []byte            // request params
+	Result []byte            // response result
+	Error  *resError         // response error
+	Meta   map[string]string // request or notification metadata
 }
 
 type resError struct {
-	Code    int             `json:"code"`
-	Message string          `json:"message"`
-	Data    json.RawMessage `json:"data,omitempty"`
+	Code    int
+	Message string
+	Data    []byte
+}
+
+// decodeMessage decodes the envelope of a raw message with given codec.
+func decodeMessage(c Codec, data []byte) (*message, error) {
+	fields, err := c.UnmarshalFields(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var m message
+	if err := unmarshalField(c, fields, "id", &m.ID); err != nil {
+		return nil, err
+	}
+	if err := unmarshalField(c, fields, "method", &m.Method); err != nil {
+		return nil, err
+	}
+	// metadata is not a part of JSON-RPC 2.0, so a malformed one sent by a foreign peer is ignored rather than failing the message
+	if err := unmarshalField(c, fields, "meta", &m.Meta); err != nil {
+		m.Meta = nil
+	}
+	m.Params = fields["params"]
+	m.Result = fields["result"]
+
+	if data, ok := fields["error"]; ok {
+		errFields, err := c.UnmarshalFields(data)
+		if err != nil {
+			return nil, err
+		}
+		if errFields != nil {
+			m.Error = &resError{Data: errFields["data"]}
+			if err := unmarshalField(c, errFields, "code", &m.Error.Code); err != nil {
+				return nil, err
+			}
+			if err := unmarshalField(c, errFields, "message", &m.Error.Message); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &m, nil
+}
+
+func unmarshalField(c Codec, fields map[string][]byte, name string, v interface{}) error {
+	if data, ok := fields[name]; ok {
+		if err := c.Unmarshal(data, v); err != nil {
+			return fmt.Errorf("invalid %v field: %v", name, err)
+		}
+	}
+
+	return nil
 }
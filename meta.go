@@ -0,0 +1,17 @@
+package jsonrpc
+
+import "context"
+
+type metaKey struct{}
+
+// ContextWithMeta returns a copy of the parent context carrying given message metadata.
+// Requests sent with Call or SendRequestContext using the returned context carry the metadata in their envelope.
+func ContextWithMeta(parent context.Context, meta map[string]string) context.Context {
+	return context.WithValue(parent, metaKey{}, meta)
+}
+
+// MetaFromContext returns the message metadata carried by the context, if any.
+func MetaFromContext(ctx context.Context) map[string]string {
+	meta, _ := ctx.Value(metaKey{}).(map[string]string)
+	return meta
+}
@@ -1,17 +1,27 @@
 package jsonrpc
 
 import (
-	"encoding/json"
 	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
 
-	"github.com/neptulon/neptulon"
+	"github.com/neptulon/cmap"
 )
 
 // MiddlewareHandler defines the middleware registrar functions for a middleware stack.
 type MiddlewareHandler interface {
+	RouteHandler
+	ResMiddleware(resMiddleware ...func(ctx *ResCtx) error)
+}
+
+// RouteHandler defines the middleware registrar functions for the messages routed by method name, which is implemented by both the middleware stacks and Router.
+// JSON-RPC middleware that handles requests and notifications only (i.e. NewRouter, NewValidator) can be registered on a namespace through it (see Router.Group),
+// while middleware that handles responses as well requires a MiddlewareHandler.
+type RouteHandler interface {
 	ReqMiddleware(reqMiddleware ...func(ctx *ReqCtx) error)
 	NotMiddleware(notMiddleware ...func(ctx *NotCtx) error)
-	ResMiddleware(resMiddleware ...func(ctx *ResCtx) error)
 }
 
 // Middleware is a Neptulon middleware for handling JSON-RPC protocol and relevant JSON-RPC middleware.
@@ -19,48 +29,262 @@ type Middleware struct {
 	reqMiddleware []func(ctx *ReqCtx) error
 	notMiddleware []func(ctx *NotCtx) error
 	resMiddleware []func(ctx *ResCtx) error
+
+	codec      Codec               // codec for all connections (JSON if nil)
+	extensions []string            // protocol extensions enabled for all connections
+	mutex      sync.RWMutex        // guards middleware stacks, codec, extension, and hook settings
+	connCodecs map[string]Codec    // connection ID -> Codec : per connection codec overrides
+	connExts   map[string][]string // connection ID -> extensions : per connection extension overrides
+	peers      map[string]*Peer    // connection ID -> Peer
+
+	compressionThreshold int           // see UseCompression
+	decompressionLimit   int           // see UseDecompressionLimit
+	drainer              drainer       // see Server.Shutdown
+	heartbeatInterval    time.Duration // see UseHeartbeat
+	heartbeatMisses      int           // see UseHeartbeat
+
+	unhandledReq []func(ctx *ReqCtx) error // see UnhandledRequest
+	unhandledNot []func(ctx *NotCtx) error // see UnhandledNotification
+	unhandledRes []func(ctx *ResCtx) error // see UnhandledResponse
+	deadLetter   func(connID string, msg []byte)
+}
+
+// UseCodec sets the codec used for encoding and decoding messages on all connections. Default codec is JSON.
+func (mw *Middleware) UseCodec(codec Codec) {
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+
+	mw.codec = codec
+}
+
+// UseConnCodec sets the codec used for encoding and decoding messages on the connection denoted by the connection ID,
+// overriding the codec set with UseCodec. Passing nil codec removes the override.
+func (mw *Middleware) UseConnCodec(connID string, codec Codec) {
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+
+	if codec == nil {
+		delete(mw.connCodecs, connID)
+		return
+	}
+
+	if mw.connCodecs == nil {
+		mw.connCodecs = make(map[string]Codec)
+	}
+	mw.connCodecs[connID] = codec
+}
+
+// Codec returns the codec in use for the connection denoted by the connection ID.
+func (mw *Middleware) Codec(connID string) Codec {
+	mw.mutex.RLock()
+	defer mw.mutex.RUnlock()
+
+	if codec, ok := mw.connCodecs[connID]; ok {
+		return codec
+	}
+	if mw.codec != nil {
+		return mw.codec
+	}
+
+	return JSON
+}
+
+// UseExtensions enables the given protocol extensions (i.e. "compression") on all connections.
+func (mw *Middleware) UseExtensions(extensions ...string) {
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+
+	mw.extensions = extensions
+}
+
+// UseConnExtensions enables the given protocol extensions on the connection denoted by the connection ID,
+// overriding the extensions set with UseExtensions. Passing nil extensions removes the override.
+func (mw *Middleware) UseConnExtensions(connID string, extensions []string) {
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+
+	if extensions == nil {
+		delete(mw.connExts, connID)
+		return
+	}
+
+	if mw.connExts == nil {
+		mw.connExts = make(map[string][]string)
+	}
+	mw.connExts[connID] = extensions
+}
+
+// HasExtension tells whether the given protocol extension is enabled on the connection denoted by the connection ID.
+func (mw *Middleware) HasExtension(connID string, extension string) bool {
+	mw.mutex.RLock()
+	defer mw.mutex.RUnlock()
+
+	if exts, ok := mw.connExts[connID]; ok {
+		return contains(exts, extension)
+	}
+
+	return contains(mw.extensions, extension)
+}
+
+// forgetConn removes all per connection overrides and the peer of a closed connection.
+func (mw *Middleware) forgetConn(connID string) {
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+
+	delete(mw.connCodecs, connID)
+	delete(mw.connExts, connID)
+	if p, ok := mw.peers[connID]; ok {
+		p.stopHeartbeat()
+		p.cancel()
+		delete(mw.peers, connID)
+	}
 }
 
 // ReqMiddleware registers middleware to handle request messages.
 func (mw *Middleware) ReqMiddleware(reqMiddleware ...func(ctx *ReqCtx) error) {
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+
 	mw.reqMiddleware = append(mw.reqMiddleware, reqMiddleware...)
 }
 
 // NotMiddleware registers middleware to handle notification messages.
 func (mw *Middleware) NotMiddleware(notMiddleware ...func(ctx *NotCtx) error) {
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+
 	mw.notMiddleware = append(mw.notMiddleware, notMiddleware...)
 }
 
 // ResMiddleware registers middleware to handle response messages.
 func (mw *Middleware) ResMiddleware(resMiddleware ...func(ctx *ResCtx) error) {
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+
 	mw.resMiddleware = append(mw.resMiddleware, resMiddleware...)
 }
 
-// NeptulonMiddleware handles raw messages,
-// categorizes the messages as one of the three JSON-RPC message types (if they are so),
-// and triggers relevant middleware.
-func (mw *Middleware) neptulonMiddleware(ctx *neptulon.Ctx) error {
-	var m message
-	if err := json.Unmarshal(ctx.Msg, &m); err != nil {
-		return fmt.Errorf("cannot deserialize message: %v", err)
+// Serve receives messages from the given connection and handles them with this middleware stack until the connection is closed.
+// Each message is handled in a separate goroutine so handlers are free to send requests and wait for their responses.
+// Returns nil if the connection was closed gracefully.
+func (mw *Middleware) Serve(c Conn) error {
+	mw.peer(c) // so the connection is known (i.e. pinged) before it sends any messages
+
+	for {
+		msg, err := c.Receive()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		mw.goHandleMsg(c, msg)
+	}
+}
+
+// HandleMsg handles a raw message received through the given connection,
+// categorizes the message as one of the three JSON-RPC message types (if it is so),
+// and triggers relevant middleware. Messages that are not JSON-RPC messages are ignored.
+// Useful for transports which push incoming messages rather than implementing Conn.Receive.
+func (mw *Middleware) HandleMsg(c Conn, msg []byte) error {
+	_, err := mw.handleMsg(c, msg, cmap.New())
+	return err
+}
+
+// handleMsg handles a raw message received through the given connection,
+// categorizes the message as one of the three JSON-RPC message types (if it is so),
+// and triggers relevant middleware. Returns false if the message is not a JSON-RPC message.
+func (mw *Middleware) handleMsg(c Conn, msg []byte, session *cmap.CMap) (ok bool, err error) {
+	if msg, err = mw.decompress(c.ConnID(), msg); err != nil {
+		return false, fmt.Errorf("cannot decompress message: %v", err)
 	}
 
+	// per connection handlers and response routes of the peer come after the shared stack
+	peer := mw.peer(c)
+	peer.touch()
+
+	client := mw.connClient(c, peer)
+	codec := client.codec
+	m, err := decodeMessage(codec, msg)
+	if err != nil {
+		return false, fmt.Errorf("cannot deserialize message: %v", err)
+	}
+
+	// take a capped snapshot of the stacks so middleware can be registered concurrently, and appending to the stack copies it
+	mw.mutex.RLock()
+	reqMiddleware := mw.reqMiddleware[:len(mw.reqMiddleware):len(mw.reqMiddleware)]
+	notMiddleware := mw.notMiddleware[:len(mw.notMiddleware):len(mw.notMiddleware)]
+	resMiddleware := mw.resMiddleware[:len(mw.resMiddleware):len(mw.resMiddleware)]
+	mw.mutex.RUnlock()
+
 	// if the message is a request or response
 	if m.ID != "" {
 		// if the message is a request
 		if m.Method != "" {
-			return newReqCtx(m.ID, m.Method, m.Params, ctx.Client, mw.reqMiddleware, ctx.Session()).Next()
+			if m.Method == PingMethod {
+				return true, client.SendResponse(m.ID, true, nil)
+			}
+			if !mw.drainer.start() {
+				return true, client.SendResponse(m.ID, nil, &ResError{Code: ShutdownCode, Message: "Server is shutting down"})
+			}
+			defer mw.drainer.done()
+
+			ctx := newReqCtx(m.ID, m.Method, m.Params, client, append(reqMiddleware, peer.router.routeReq, mw.unhandledRequest), session, codec)
+			ctx.Peer, ctx.meta, ctx.raw, ctx.context, ctx.drainer = peer, m.Meta, msg, peer.context, &mw.drainer
+			return true, ctx.Next()
 		}
 
 		// if the message is a response
-		return newResCtx(m.ID, m.Result, ctx.Client, mw.resMiddleware, ctx.Session()).Next()
+		ctx := newResCtx(m.ID, m.Result, m.Error, client, append(resMiddleware, peer.sender.resMiddleware, mw.unhandledResponse), session, codec)
+		ctx.Peer, ctx.raw = peer, msg
+		return true, ctx.Next()
 	}
 
 	// if the message is a notification
 	if m.Method != "" {
-		return newNotCtx(m.Method, m.Params, ctx.Client, mw.notMiddleware, ctx.Session()).Next()
+		ctx := newNotCtx(m.Method, m.Params, client, append(notMiddleware, peer.router.routeNot, mw.unhandledNotification), session, codec)
+		ctx.Peer, ctx.meta, ctx.raw = peer, m.Meta, msg
+		return true, ctx.Next()
 	}
 
-	// not a JSON-RPC message so do nothing
-	return ctx.Next()
+	return false, nil
+}
+
+// connClient wraps the connection that a message was received from into a Client,
+// which uses the same codec, extension, and compression settings as this middleware does for the connection.
+// Client shares the response routes of the peer, so responses to the requests sent by handlers through ctx.Client are delivered.
+func (mw *Middleware) connClient(c Conn, peer *Peer) *Client {
+	client := UseConn(c)
+	client.sender.resRoutes = peer.sender.resRoutes
+	client.sender.registeredResponseMiddleware.Do(func() {}) // responses are dispatched to the peer, see handleMsg
+	connID := c.ConnID()
+
+	mw.mutex.RLock()
+	defer mw.mutex.RUnlock()
+
+	client.codec = mw.codec
+	if codec, ok := mw.connCodecs[connID]; ok {
+		client.codec = codec
+	}
+	if client.codec == nil {
+		client.codec = JSON
+	}
+
+	client.extensions = mw.extensions
+	if exts, ok := mw.connExts[connID]; ok {
+		client.extensions = exts
+	}
+
+	client.compressionThreshold = mw.compressionThreshold
+	return client
+}
+
+// goHandleMsg handles a raw message received through the given connection in a separate goroutine.
+func (mw *Middleware) goHandleMsg(c Conn, msg []byte) {
+	go func() {
+		if err := mw.HandleMsg(c, msg); err != nil {
+			log.Printf("jsonrpc: error handling message from %v: %v", c.ConnID(), err)
+		}
+	}()
 }
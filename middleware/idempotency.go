@@ -0,0 +1,263 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/neptulon/jsonrpc"
+)
+
+// IdempotencyKey is the message metadata key carrying the idempotency key of a request.
+const IdempotencyKey = "idempotency-key"
+
+// IdempotencyScopeKey is the connection session key that the default scope of the idempotency keys is read from, see Idempotency.Scope.
+const IdempotencyScopeKey = "idempotency-scope"
+
+// Defaults of Idempotency middleware.
+const (
+	DefaultIdempotencyTTL   = time.Hour * 24
+	DefaultIdempotencyParam = "idempotencyKey"
+	DefaultIdempotencyWait  = time.Second * 30
+)
+
+// CachedResponse is a response stored for replaying to the duplicates of a request.
+type CachedResponse struct {
+	Result interface{}
+	Error  *jsonrpc.ResError
+	Params string // hash of the params of the request, to reject the duplicates with different params
+}
+
+// IdempotencyStore stores the responses of requests with idempotency keys. Implementations should be safe for concurrent use.
+// Keys are scoped to the caller and the method name by the middleware already.
+type IdempotencyStore interface {
+	// Get returns the response stored with given key, or nil if there is no such response or it has expired.
+	Get(key string) (*CachedResponse, error)
+
+	// Set stores the response with given key, to be expired after given duration.
+	Set(key string, res *CachedResponse, ttl time.Duration) error
+}
+
+// Idempotency is a middleware replaying the cached response to the duplicates of a request carrying the same idempotency key,
+// so retried requests (i.e. after a connection drop) do not run side effects twice.
+// Key is read from the "idempotency-key" metadata of the request, or the Param field of by-name params.
+// Requests without a key are passed on as is. Duplicates arriving while the first request is still running wait for its response, up to Wait.
+// Duplicates with params different from the first request are answered with -32602 (invalid params) error rather than the cached response.
+// Params are compared by value, so the key order, whitespace, and codec of the params do not matter.
+// Responses are cached once written, except for internal errors (-32603), so failed requests can be retried.
+// Responses written asynchronously (see ReqCtx.Hold) are not cached, as they are not set by the time the rest of the middleware stack returns.
+// Keys are scoped to the caller (see Scope), so a client cannot replay the responses of the others.
+// Should be registered after authentication, but before the routes.
+type Idempotency struct {
+	TTL   time.Duration    // Duration to keep the responses for. Default is DefaultIdempotencyTTL.
+	Param string           // Name of the params field to read the key from. Default is DefaultIdempotencyParam.
+	Store IdempotencyStore // Response store. Default is an in-memory store. Should be set before serving.
+	Wait  time.Duration    // Time for a duplicate to wait for the running request, before being answered with -32603 (internal error). Default is DefaultIdempotencyWait.
+
+	// Scope returns the identity of the caller that the keys are scoped to (i.e. the authenticated user).
+	// Default is the string stored under IdempotencyScopeKey in the connection session (i.e. by the authentication middleware),
+	// or the connection ID if there is none, in which case only the retries over the same connection are replayed.
+	Scope func(ctx *jsonrpc.ReqCtx) string
+
+	mutex    sync.Mutex
+	inFlight map[string]chan struct{} // key -> closed when the request holding the key is answered
+}
+
+// NewIdempotency creates an idempotency middleware with an in-memory store and registers it as a Neptulon JSON-RPC middleware.
+func NewIdempotency(m jsonrpc.RouteHandler) (*Idempotency, error) {
+	if m == nil {
+		return nil, errors.New("given JSON-RPC Middleware instance is nil")
+	}
+
+	i := Idempotency{Store: NewMemoryIdempotencyStore(), inFlight: make(map[string]chan struct{})}
+	m.ReqMiddleware(i.reqMiddleware)
+	return &i, nil
+}
+
+func (i *Idempotency) reqMiddleware(ctx *jsonrpc.ReqCtx) error {
+	key := i.key(ctx)
+	if key == "" {
+		return ctx.Next()
+	}
+	key = i.scope(ctx) + "\x00" + ctx.Method() + "\x00" + key
+	params := paramsHash(ctx)
+
+	var wait <-chan time.Time
+	for {
+		res, err := i.Store.Get(key)
+		if err != nil {
+			log.Printf("jsonrpc: idempotency store error reading response for %v: %v", ctx.Method(), err)
+		}
+		if res != nil {
+			if res.Params != params {
+				ctx.Err = &jsonrpc.ResError{Code: -32602, Message: "Invalid params", Data: "idempotency key is already used with different params"}
+			} else {
+				ctx.Res, ctx.Err = res.Result, res.Error
+			}
+			return ctx.Respond()
+		}
+
+		// either run the request, or wait for the running duplicate and check the store again
+		i.mutex.Lock()
+		done, running := i.inFlight[key]
+		if !running {
+			done = make(chan struct{})
+			i.inFlight[key] = done
+		}
+		i.mutex.Unlock()
+
+		if !running {
+			break
+		}
+
+		if wait == nil {
+			wait = time.After(i.wait())
+		}
+		select {
+		case <-done:
+		case <-wait:
+			ctx.Err = &jsonrpc.ResError{Code: -32603, Message: "Internal error", Data: "request with the same idempotency key is still running"}
+			return ctx.Respond()
+		case <-ctx.Context().Done():
+			return ctx.Context().Err()
+		}
+	}
+
+	defer func() {
+		i.mutex.Lock()
+		close(i.inFlight[key])
+		delete(i.inFlight, key)
+		i.mutex.Unlock()
+	}()
+
+	if err := ctx.Next(); err != nil {
+		return err
+	}
+
+	if (ctx.Res == nil && ctx.Err == nil) || (ctx.Err != nil && ctx.Err.Code == -32603) {
+		return nil
+	}
+	if err := i.Store.Set(key, &CachedResponse{Result: ctx.Res, Error: ctx.Err, Params: params}, i.ttl()); err != nil {
+		log.Printf("jsonrpc: idempotency store error writing response for %v: %v", ctx.Method(), err)
+	}
+
+	return nil
+}
+
+// key returns the idempotency key of the request from the metadata or the params, if any.
+func (i *Idempotency) key(ctx *jsonrpc.ReqCtx) string {
+	if key := ctx.Meta()[IdempotencyKey]; key != "" {
+		return key
+	}
+
+	param := i.Param
+	if param == "" {
+		param = DefaultIdempotencyParam
+	}
+
+	var params map[string]interface{}
+	if err := ctx.Params(&params); err != nil {
+		return "" // not by-name params
+	}
+	key, _ := params[param].(string)
+	return key
+}
+
+// scope returns the identity of the caller that the keys of the request are scoped to.
+func (i *Idempotency) scope(ctx *jsonrpc.ReqCtx) string {
+	if i.Scope != nil {
+		return i.Scope(ctx)
+	}
+
+	if scope, ok := ctx.Client.Session().Get(IdempotencyScopeKey).(string); ok && scope != "" {
+		return scope
+	}
+	return "conn:" + ctx.Client.ConnID()
+}
+
+// paramsHash returns the hash of the params of the request re-encoded as JSON, which sorts the object keys,
+// so the duplicates encoded differently are recognized. Params that cannot be re-encoded are hashed as they are.
+func paramsHash(ctx *jsonrpc.ReqCtx) string {
+	var params interface{}
+	var data []byte
+	if err := ctx.Params(&params); err == nil {
+		data, _ = json.Marshal(params)
+	}
+	if data == nil {
+		var raw jsonrpc.RawMessage
+		ctx.Params(&raw)
+		data = raw.Data
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (i *Idempotency) wait() time.Duration {
+	if i.Wait == 0 {
+		return DefaultIdempotencyWait
+	}
+
+	return i.Wait
+}
+
+func (i *Idempotency) ttl() time.Duration {
+	if i.TTL == 0 {
+		return DefaultIdempotencyTTL
+	}
+
+	return i.TTL
+}
+
+// MemoryIdempotencyStore is an in-memory IdempotencyStore. Expired responses are removed as new ones are stored.
+type MemoryIdempotencyStore struct {
+	mutex     sync.Mutex
+	responses map[string]memoryIdempotencyEntry
+	sweep     time.Time // next time to remove the expired responses
+}
+
+type memoryIdempotencyEntry struct {
+	res     *CachedResponse
+	expires time.Time
+}
+
+// NewMemoryIdempotencyStore creates an empty in-memory store.
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{responses: make(map[string]memoryIdempotencyEntry)}
+}
+
+// Get returns the response stored with given key, or nil if there is no such response or it has expired.
+func (s *MemoryIdempotencyStore) Get(key string) (*CachedResponse, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	e, ok := s.responses[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil, nil
+	}
+
+	return e.res, nil
+}
+
+// Set stores the response with given key, to be expired after given duration.
+func (s *MemoryIdempotencyStore) Set(key string, res *CachedResponse, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	if now.After(s.sweep) {
+		for k, e := range s.responses {
+			if now.After(e.expires) {
+				delete(s.responses, k)
+			}
+		}
+		s.sweep = now.Add(ttl)
+	}
+
+	s.responses[key] = memoryIdempotencyEntry{res: res, expires: now.Add(ttl)}
+	return nil
+}
@@ -0,0 +1,304 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/neptulon/jsonrpc"
+)
+
+// DefaultBuckets are the default upper bounds of request latency histogram buckets, in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics is a middleware collecting per method JSON-RPC metrics, which are served in Prometheus text exposition format as an http.Handler.
+// Metrics should be registered before any other middleware for the latency to cover the whole middleware stack.
+// Requests and notifications of the methods that no route handles are counted under the UnknownMethod label,
+// so that the label set cannot grow with arbitrary method names sent by the clients.
+type Metrics struct {
+	Buckets []float64 // Upper bounds of latency histogram buckets in seconds. Default is DefaultBuckets.
+
+	mutex          sync.Mutex
+	requests       map[string]uint64            // method -> count
+	errors         map[errorKey]uint64          // method and error code -> count
+	latency        map[string]*histogram        // method -> request latency
+	inFlight       map[string]int64             // method -> requests being handled
+	notifications  map[string]uint64            // method -> count
+	responses      uint64                       // responses received for outbound requests
+	responseErrors map[int]uint64               // error code -> responses received with error
+	unhandled      map[string]uint64            // message type -> unhandled messages received
+	senders        []interface{ Pending() int } // senders to report pending outbound requests of
+	unrouted       map[interface{}]bool         // contexts of the messages being handled -> whether they have reached the unhandled message hooks
+}
+
+// UnknownMethod is the method label of the requests and notifications that no route handles.
+const UnknownMethod = "unknown"
+
+type errorKey struct {
+	method string
+	code   int
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, non-cumulative
+	count  uint64
+	sum    float64
+}
+
+// NewMetrics creates a metrics middleware and registers it as a Neptulon JSON-RPC middleware.
+// Unhandled messages are also counted if given middleware handler is a jsonrpc.UnhandledHandler (i.e. *jsonrpc.Server), see CountUnhandled.
+// Otherwise, only the requests answered with -32601 (method not found) error are counted under the UnknownMethod label.
+func NewMetrics(m jsonrpc.MiddlewareHandler) (*Metrics, error) {
+	if m == nil {
+		return nil, errors.New("given JSON-RPC Middleware instance is nil")
+	}
+
+	mt := Metrics{
+		requests:       make(map[string]uint64),
+		errors:         make(map[errorKey]uint64),
+		latency:        make(map[string]*histogram),
+		inFlight:       make(map[string]int64),
+		notifications:  make(map[string]uint64),
+		responseErrors: make(map[int]uint64),
+		unhandled:      make(map[string]uint64),
+		unrouted:       make(map[interface{}]bool),
+	}
+
+	m.ReqMiddleware(mt.reqMiddleware)
+	m.NotMiddleware(mt.notMiddleware)
+	m.ResMiddleware(mt.resMiddleware)
+	if u, ok := m.(jsonrpc.UnhandledHandler); ok {
+		mt.CountUnhandled(u)
+	}
+	return &mt, nil
+}
+
+// CountUnhandled registers hooks counting the unhandled messages of given middleware stack (i.e. *jsonrpc.Server),
+// which is only needed if NewMetrics was given something else than the stack itself (i.e. a jsonrpc.Router group).
+func (mt *Metrics) CountUnhandled(u jsonrpc.UnhandledHandler) {
+	u.UnhandledRequest(func(ctx *jsonrpc.ReqCtx) error { return mt.countUnhandled("request", ctx, ctx.Next) })
+	u.UnhandledNotification(func(ctx *jsonrpc.NotCtx) error { return mt.countUnhandled("notification", ctx, ctx.Next) })
+	u.UnhandledResponse(func(ctx *jsonrpc.ResCtx) error { return mt.countUnhandled("response", nil, ctx.Next) })
+}
+
+// TrackPending reports the number of outbound requests waiting for a response on given sender (i.e. *jsonrpc.Client or *jsonrpc.Sender).
+// Only the tracked senders are reported, so the requests sent through the peers of the connections (see jsonrpc.Peer) are not included.
+func (mt *Metrics) TrackPending(sender interface{ Pending() int }) {
+	mt.mutex.Lock()
+	defer mt.mutex.Unlock()
+
+	mt.senders = append(mt.senders, sender)
+}
+
+func (mt *Metrics) reqMiddleware(ctx *jsonrpc.ReqCtx) error {
+	method := ctx.Method()
+	mt.mutex.Lock()
+	mt.inFlight[method]++
+	mt.unrouted[ctx] = false
+	mt.mutex.Unlock()
+
+	start := time.Now()
+	err := ctx.Next()
+	elapsed := time.Since(start).Seconds()
+
+	mt.mutex.Lock()
+	defer mt.mutex.Unlock()
+
+	// in flight requests are only reported for the counted methods, so the entries of unknown methods are not kept around
+	if mt.inFlight[method]--; mt.inFlight[method] == 0 {
+		delete(mt.inFlight, method)
+	}
+	if mt.unrouted[ctx] || (ctx.Err != nil && ctx.Err.Code == -32601) {
+		method = UnknownMethod
+	}
+	delete(mt.unrouted, ctx)
+	mt.requests[method]++
+	if ctx.Err != nil {
+		mt.errors[errorKey{method, ctx.Err.Code}]++
+	}
+	h, ok := mt.latency[method]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(mt.buckets()))}
+		mt.latency[method] = h
+	}
+	h.observe(mt.buckets(), elapsed)
+
+	return err
+}
+
+func (mt *Metrics) notMiddleware(ctx *jsonrpc.NotCtx) error {
+	mt.mutex.Lock()
+	mt.unrouted[ctx] = false
+	mt.mutex.Unlock()
+
+	err := ctx.Next()
+
+	mt.mutex.Lock()
+	defer mt.mutex.Unlock()
+
+	method := ctx.Method()
+	if mt.unrouted[ctx] {
+		method = UnknownMethod
+	}
+	delete(mt.unrouted, ctx)
+	mt.notifications[method]++
+
+	return err
+}
+
+func (mt *Metrics) resMiddleware(ctx *jsonrpc.ResCtx) error {
+	mt.mutex.Lock()
+	mt.responses++
+	if err := ctx.Err; err != nil {
+		mt.responseErrors[err.Code]++
+	}
+	mt.mutex.Unlock()
+
+	return ctx.Next()
+}
+
+// countUnhandled counts an unhandled message, and marks its context (if given) for the message to be counted under the UnknownMethod label.
+// Only the contexts of the messages being handled by the metrics middleware are marked, so the marks are always cleared.
+func (mt *Metrics) countUnhandled(typ string, ctx interface{}, next func() error) error {
+	mt.mutex.Lock()
+	mt.unhandled[typ]++
+	if _, ok := mt.unrouted[ctx]; ok {
+		mt.unrouted[ctx] = true
+	}
+	mt.mutex.Unlock()
+
+	return next()
+}
+
+func (mt *Metrics) buckets() []float64 {
+	if mt.Buckets == nil {
+		return DefaultBuckets
+	}
+
+	return mt.Buckets
+}
+
+func (h *histogram) observe(buckets []float64, v float64) {
+	for i, b := range buckets {
+		if v <= b {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// ServeHTTP writes all the metrics in Prometheus text exposition format.
+func (mt *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	mt.WriteTo(w)
+}
+
+// WriteTo writes all the metrics in Prometheus text exposition format to given writer.
+func (mt *Metrics) WriteTo(w io.Writer) (int64, error) {
+	mt.mutex.Lock()
+	senders := mt.senders
+	var b strings.Builder
+
+	header(&b, "jsonrpc_requests_total", "counter", "Total number of requests received.")
+	for _, method := range sortedMethods(mt.requests) {
+		fmt.Fprintf(&b, "jsonrpc_requests_total{method=%v} %v\n", label(method), mt.requests[method])
+	}
+
+	header(&b, "jsonrpc_request_errors_total", "counter", "Total number of requests answered with an error, by error code.")
+	errs := make([]errorKey, 0, len(mt.errors))
+	for k := range mt.errors {
+		errs = append(errs, k)
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		if errs[i].method != errs[j].method {
+			return errs[i].method < errs[j].method
+		}
+		return errs[i].code < errs[j].code
+	})
+	for _, k := range errs {
+		fmt.Fprintf(&b, "jsonrpc_request_errors_total{method=%v,code=\"%v\"} %v\n", label(k.method), k.code, mt.errors[k])
+	}
+
+	header(&b, "jsonrpc_request_duration_seconds", "histogram", "Time spent handling requests.")
+	buckets := mt.buckets()
+	for _, method := range sortedMethods(mt.requests) {
+		h, ok := mt.latency[method]
+		if !ok {
+			continue // still in flight
+		}
+		var cumulative uint64
+		for i, bound := range buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&b, "jsonrpc_request_duration_seconds_bucket{method=%v,le=\"%v\"} %v\n", label(method), strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(&b, "jsonrpc_request_duration_seconds_bucket{method=%v,le=\"+Inf\"} %v\n", label(method), h.count)
+		fmt.Fprintf(&b, "jsonrpc_request_duration_seconds_sum{method=%v} %v\n", label(method), strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(&b, "jsonrpc_request_duration_seconds_count{method=%v} %v\n", label(method), h.count)
+	}
+
+	header(&b, "jsonrpc_requests_in_flight", "gauge", "Number of requests being handled.")
+	for _, method := range sortedMethods(mt.requests) {
+		fmt.Fprintf(&b, "jsonrpc_requests_in_flight{method=%v} %v\n", label(method), mt.inFlight[method])
+	}
+
+	header(&b, "jsonrpc_notifications_total", "counter", "Total number of notifications received.")
+	for _, method := range sortedMethods(mt.notifications) {
+		fmt.Fprintf(&b, "jsonrpc_notifications_total{method=%v} %v\n", label(method), mt.notifications[method])
+	}
+
+	header(&b, "jsonrpc_responses_total", "counter", "Total number of responses received for outbound requests.")
+	fmt.Fprintf(&b, "jsonrpc_responses_total %v\n", mt.responses)
+
+	header(&b, "jsonrpc_response_errors_total", "counter", "Total number of error responses received for outbound requests, by error code.")
+	codes := make([]int, 0, len(mt.responseErrors))
+	for code := range mt.responseErrors {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(&b, "jsonrpc_response_errors_total{code=\"%v\"} %v\n", code, mt.responseErrors[code])
+	}
+
+	header(&b, "jsonrpc_unhandled_total", "counter", "Total number of messages received that no middleware handled, by message type.")
+	for _, typ := range sortedMethods(mt.unhandled) {
+		fmt.Fprintf(&b, "jsonrpc_unhandled_total{type=%v} %v\n", label(typ), mt.unhandled[typ])
+	}
+	mt.mutex.Unlock()
+
+	// senders are queried outside the lock as they have locks of their own
+	pending := 0
+	for _, s := range senders {
+		pending += s.Pending()
+	}
+	header(&b, "jsonrpc_pending_requests", "gauge", "Number of outbound requests waiting for a response.")
+	fmt.Fprintf(&b, "jsonrpc_pending_requests %v\n", pending)
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func header(b *strings.Builder, name, typ, help string) {
+	fmt.Fprintf(b, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, typ)
+}
+
+// label quotes and escapes a label value as required by the text exposition format.
+func label(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v) + `"`
+}
+
+func sortedMethods(m map[string]uint64) []string {
+	methods := make([]string, 0, len(m))
+	for method := range m {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/neptulon/jsonrpc"
+)
+
+// Message metadata keys of W3C Trace Context (https://www.w3.org/TR/trace-context/).
+const (
+	TraceparentKey = "traceparent"
+	TracestateKey  = "tracestate"
+)
+
+// Span is a unit of work in a distributed trace, identified as in W3C Trace Context.
+type Span struct {
+	TraceID  string // 16 bytes, hex encoded
+	SpanID   string // 8 bytes, hex encoded
+	ParentID string // span ID of the caller, empty for root spans
+	Sampled  bool   // whether the trace is recorded by the caller
+	Method   string
+	Start    time.Time
+	End      time.Time
+}
+
+// Traceparent formats the span as a W3C traceparent header value to be propagated to callees.
+func (s *Span) Traceparent() string {
+	flags := "00"
+	if s.Sampled {
+		flags = "01"
+	}
+
+	return fmt.Sprintf("00-%v-%v-%v", s.TraceID, s.SpanID, flags)
+}
+
+type spanKey struct{}
+
+// SpanFromContext returns the span of the request being handled, if Tracing middleware is in use.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// Tracing is a middleware starting a span for each incoming request, as a child of the span in the "traceparent" metadata of the request (if any).
+// Span is stored in the request context, along with the metadata to propagate it, so requests sent by the handler with ctx.Context() carry the trace on.
+type Tracing struct {
+	OnSpan func(span *Span) // Optional. Called when a span ends, i.e. to export it. Should be set before serving.
+}
+
+// NewTracing creates a tracing middleware and registers it as a Neptulon JSON-RPC middleware.
+func NewTracing(m jsonrpc.RouteHandler) (*Tracing, error) {
+	if m == nil {
+		return nil, errors.New("given JSON-RPC Middleware instance is nil")
+	}
+
+	t := Tracing{}
+	m.ReqMiddleware(t.reqMiddleware)
+	return &t, nil
+}
+
+func (t *Tracing) reqMiddleware(ctx *jsonrpc.ReqCtx) error {
+	incoming := ctx.Meta()
+	span := &Span{Method: ctx.Method(), Start: time.Now(), SpanID: randomHex(8)}
+	if traceID, parentID, sampled, ok := parseTraceparent(incoming[TraceparentKey]); ok {
+		span.TraceID, span.ParentID, span.Sampled = traceID, parentID, sampled
+	} else {
+		span.TraceID, span.Sampled = randomHex(16), true
+	}
+
+	meta := map[string]string{}
+	for k, v := range jsonrpc.MetaFromContext(ctx.Context()) {
+		meta[k] = v
+	}
+	meta[TraceparentKey] = span.Traceparent()
+	if state, ok := incoming[TracestateKey]; ok {
+		meta[TracestateKey] = state
+	}
+	ctx.SetContext(jsonrpc.ContextWithMeta(context.WithValue(ctx.Context(), spanKey{}, span), meta))
+
+	err := ctx.Next()
+	span.End = time.Now()
+	if t.OnSpan != nil {
+		t.OnSpan(span)
+	}
+
+	return err
+}
+
+// parseTraceparent parses a traceparent value in "version-traceid-parentid-flags" format.
+func parseTraceparent(v string) (traceID, parentID string, sampled bool, ok bool) {
+	parts := strings.Split(v, "-")
+	if len(parts) < 4 || !isHex(parts[0], 2) || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return "", "", false, false
+	}
+	if !isHex(parts[1], 32) || !isHex(parts[2], 16) || !isHex(parts[3], 2) {
+		return "", "", false, false
+	}
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return "", "", false, false // all zero IDs are invalid
+	}
+
+	flags, _ := hex.DecodeString(parts[3])
+	return parts[1], parts[2], flags[0]&1 == 1, true
+}
+
+// isHex tells whether s is a lowercase hex string of given length.
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
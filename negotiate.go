@@ -0,0 +1,145 @@
+package jsonrpc
+
+import (
+	"sync"
+	"time"
+)
+
+// negotiateMethod is the reserved method name for codec and extension negotiation between peers.
+const negotiateMethod = "rpc.negotiate"
+
+// negotiation is the params object of an rpc.negotiate request.
+type negotiation struct {
+	Codecs     []string `json:"codecs"`               // supported codec names in order of preference
+	Extensions []string `json:"extensions,omitempty"` // supported protocol extensions
+}
+
+// negotiated is the result object of an rpc.negotiate request.
+type negotiated struct {
+	Codec      string   `json:"codec"`                // codec picked by the server
+	Extensions []string `json:"extensions,omitempty"` // extensions supported by both peers
+}
+
+// AcceptNegotiation answers rpc.negotiate requests with the first client offered codec that is among the given codecs (JSON if none),
+// and the extensions that are supported by both peers. Connection switches to the picked codec with the response, which is still sent with the codec of the request.
+// Should be registered before any other request middleware.
+func (mw *Middleware) AcceptNegotiation(codecs []Codec, extensions ...string) {
+	mw.ReqMiddleware(func(ctx *ReqCtx) error {
+		if ctx.method != negotiateMethod {
+			return ctx.Next()
+		}
+
+		var n negotiation
+		if err := ctx.Params(&n); err != nil {
+			ctx.Err = &ResError{Code: -32602, Message: "Invalid params", Data: err.Error()}
+			return ctx.Next()
+		}
+
+		codec := JSON
+		for _, name := range n.Codecs {
+			if c := findCodec(codecs, name); c != nil {
+				codec = c
+				break
+			}
+		}
+
+		exts := intersect(n.Extensions, extensions)
+		ctx.Res = negotiated{Codec: codec.Name(), Extensions: exts}
+
+		// connection switches before the response is written so the next message of the client is decoded with the picked codec,
+		// while the response itself still goes out with the codec that the client is using, as ctx.Client keeps the settings the request was received with
+		connID := ctx.Client.ConnID()
+		mw.UseConnCodec(connID, codec)
+		mw.UseConnExtensions(connID, exts)
+		return ctx.Next()
+	})
+}
+
+// Negotiate offers the given codecs (in order of preference) and extensions to the server with an rpc.negotiate request,
+// and switches to the codec and extensions picked by the server once the response arrives.
+// Client keeps using its current codec (JSON by default) if the server does not answer within the given timeout or answers with an error.
+// An answer arriving after the timeout is discarded.
+// Should be called right after Connect, before any other message is exchanged.
+func (c *Client) Negotiate(codecs []Codec, extensions []string, timeout time.Duration) error {
+	names := make([]string, len(codecs))
+	for i, codec := range codecs {
+		names[i] = codec.Name()
+	}
+
+	var (
+		mutex   sync.Mutex
+		expired bool
+	)
+	done := make(chan struct{})
+	id, err := c.SendRequest(negotiateMethod, negotiation{Codecs: names, Extensions: extensions}, func(ctx *ResCtx) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if expired {
+			return ctx.Next()
+		}
+		defer close(done)
+
+		var n negotiated
+		if ctx.Err != nil || ctx.Result(&n) != nil {
+			return ctx.Next()
+		}
+
+		if codec := findCodec(codecs, n.Codec); codec != nil {
+			c.UseCodec(codec)
+		} else {
+			c.UseCodec(JSON)
+		}
+		c.UseExtensions(intersect(n.Extensions, extensions)...)
+		return ctx.Next()
+	})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		mutex.Lock()
+		expired = true
+		mutex.Unlock()
+		c.sender.forgetRequest(id)
+	}
+
+	return nil
+}
+
+func findCodec(codecs []Codec, name string) Codec {
+	if name == JSON.Name() {
+		return JSON
+	}
+
+	for _, c := range codecs {
+		if c.Name() == name {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// intersect returns the elements of a which are also in b.
+func intersect(a, b []string) []string {
+	s := []string{}
+	for _, v := range a {
+		if contains(b, v) {
+			s = append(s, v)
+		}
+	}
+
+	return s
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+
+	return false
+}
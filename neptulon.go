@@ -0,0 +1,30 @@
+package jsonrpc
+
+import (
+	"errors"
+
+	"github.com/neptulon/neptulon"
+)
+
+// neptulonConn adapts a Neptulon client connection to Conn interface.
+// Neptulon reads from the connection by itself and pushes incoming messages through neptulonMiddleware, so Receive is not supported.
+type neptulonConn struct {
+	*neptulon.Client
+}
+
+func (c neptulonConn) Receive() ([]byte, error) {
+	return nil, errors.New("neptulon connections deliver incoming messages through middleware")
+}
+
+// NeptulonMiddleware handles raw messages,
+// categorizes the messages as one of the three JSON-RPC message types (if they are so),
+// and triggers relevant middleware.
+func (mw *Middleware) neptulonMiddleware(ctx *neptulon.Ctx) error {
+	ok, err := mw.handleMsg(neptulonConn{ctx.Client}, ctx.Msg, ctx.Session())
+	if err != nil || ok {
+		return err
+	}
+
+	// not a JSON-RPC message so do nothing
+	return ctx.Next()
+}
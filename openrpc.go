@@ -0,0 +1,128 @@
+package jsonrpc
+
+import (
+	"reflect"
+	"sort"
+)
+
+// discoverMethod is the reserved method name for service discovery, which returns an OpenRPC document.
+const discoverMethod = "rpc.discover"
+
+// OpenRPC is an OpenRPC service description document. See https://spec.open-rpc.org for details.
+type OpenRPC struct {
+	OpenRPC string          `json:"openrpc"`
+	Info    OpenRPCInfo     `json:"info"`
+	Methods []OpenRPCMethod `json:"methods"`
+}
+
+// OpenRPCInfo is the metadata of a service.
+type OpenRPCInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// OpenRPCMethod describes a request or notification method. Notifications do not have a result.
+type OpenRPCMethod struct {
+	Name           string               `json:"name"`
+	ParamStructure string               `json:"paramStructure,omitempty"` // "by-name" or "by-position"
+	Params         []*ContentDescriptor `json:"params"`
+	Result         *ContentDescriptor   `json:"result,omitempty"`
+}
+
+// ContentDescriptor describes a single param or a result.
+type ContentDescriptor struct {
+	Name     string  `json:"name"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+// Discover generates an OpenRPC document listing all the request and notification routes, including the routes of the mounted routers with their prefixes.
+// Params and result schemas are included for the routes registered with typed handlers.
+// Same document is returned to rpc.discover requests, unless a handler is registered for that route explicitly.
+func (r *Router) Discover() *OpenRPC {
+	doc := OpenRPC{OpenRPC: "1.2.6", Info: r.Info, Methods: []OpenRPCMethod{}}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for route := range r.reqRoutes {
+		t, typed := r.reqTypes[route]
+		m := describeParams(route, t.params, typed)
+		m.Result = &ContentDescriptor{Name: "result", Schema: &Schema{}}
+		if typed {
+			m.Result.Schema = schemaOf(t.result, map[reflect.Type]bool{})
+		}
+		doc.Methods = append(doc.Methods, m)
+	}
+
+	for route := range r.notRoutes {
+		t, typed := r.notTypes[route]
+		doc.Methods = append(doc.Methods, describeParams(route, t.params, typed))
+	}
+
+	for route := range r.notListeners {
+		if _, ok := r.notRoutes[route]; !ok {
+			doc.Methods = append(doc.Methods, describeParams(route, nil, false))
+		}
+	}
+
+	for _, n := range r.nested {
+		doc.Methods = append(doc.Methods, n.Discover().Methods...)
+	}
+
+	for prefix, sub := range r.mounts {
+		for _, m := range sub.Discover().Methods {
+			m.Name = prefix + m.Name
+			doc.Methods = append(doc.Methods, m)
+		}
+	}
+
+	sort.Sort(byName(doc.Methods))
+	return &doc
+}
+
+// describeParams describes a method with params of given type. Struct params are listed by name.
+func describeParams(route string, params reflect.Type, typed bool) OpenRPCMethod {
+	m := OpenRPCMethod{Name: route, Params: []*ContentDescriptor{}}
+	if !typed {
+		return m
+	}
+
+	s := schemaOf(params, map[reflect.Type]bool{})
+	if s.Type != "object" || s.Properties == nil {
+		m.ParamStructure = "by-position"
+		m.Params = append(m.Params, &ContentDescriptor{Name: "params", Required: true, Schema: s})
+		return m
+	}
+
+	m.ParamStructure = "by-name"
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m.Params = append(m.Params, &ContentDescriptor{Name: name, Required: contains(s.Required, name), Schema: s.Properties[name]})
+	}
+
+	return m
+}
+
+// discover answers rpc.discover requests. Documents of multiple routers sharing the same middleware stack are merged.
+func (r *Router) discover(ctx *ReqCtx) error {
+	doc := r.Discover()
+	if prev, ok := ctx.Res.(*OpenRPC); ok {
+		prev.Methods = append(prev.Methods, doc.Methods...)
+		sort.Sort(byName(prev.Methods))
+	} else {
+		ctx.Res = doc
+	}
+
+	return ctx.Next()
+}
+
+type byName []OpenRPCMethod
+
+func (m byName) Len() int           { return len(m) }
+func (m byName) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m byName) Less(i, j int) bool { return m[i].Name < m[j].Name }
@@ -0,0 +1,118 @@
+package jsonrpc
+
+import (
+	"sort"
+	"strings"
+)
+
+// routePattern is a route with wildcard segments, where method name segments are separated by dots:
+//
+//	{name}  matches a single segment, which is captured with given name (i.e. "events.{room}.post")
+//	*       as the last segment, matches one or more remaining segments, which are captured as "*" (i.e. "chat.*")
+//	*       elsewhere, matches a single segment without capturing it
+//
+// A route consisting of a single "*" is a catch-all, matching all methods.
+type routePattern struct {
+	route    string
+	segments []string
+}
+
+// isPattern tells whether the route contains any wildcard segments.
+func isPattern(route string) bool {
+	return strings.ContainsAny(route, "*{")
+}
+
+func newRoutePattern(route string) routePattern {
+	return routePattern{route: route, segments: strings.Split(route, ".")}
+}
+
+// match matches given method name against the pattern, returning the captured segments (if any).
+func (p routePattern) match(method string) (captures map[string]string, ok bool) {
+	segments := strings.Split(method, ".")
+	for i, seg := range p.segments {
+		if i == len(segments) {
+			return nil, false
+		}
+
+		switch {
+		case seg == "*" && i == len(p.segments)-1:
+			captures = capture(captures, "*", strings.Join(segments[i:], "."))
+			return captures, true
+		case seg == "*":
+		case strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}"):
+			captures = capture(captures, seg[1:len(seg)-1], segments[i])
+		case seg != segments[i]:
+			return nil, false
+		}
+	}
+
+	return captures, len(segments) == len(p.segments)
+}
+
+func capture(captures map[string]string, name, value string) map[string]string {
+	if captures == nil {
+		captures = make(map[string]string)
+	}
+	captures[name] = value
+	return captures
+}
+
+// rank is the specificity of a pattern segment: literal segments are more specific than captures, which are more specific than wildcards.
+func rank(seg string) int {
+	switch {
+	case seg == "*":
+		return 0
+	case strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}"):
+		return 1
+	default:
+		return 2
+	}
+}
+
+// byPrecedence sorts patterns from the most specific to the least, comparing them segment by segment from the start.
+type byPrecedence []routePattern
+
+func (p byPrecedence) Len() int      { return len(p) }
+func (p byPrecedence) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p byPrecedence) Less(i, j int) bool {
+	a, b := p[i].segments, p[j].segments
+	for k := 0; k < len(a) && k < len(b); k++ {
+		if ra, rb := rank(a[k]), rank(b[k]); ra != rb {
+			return ra > rb
+		}
+	}
+	if len(a) != len(b) {
+		return len(a) > len(b)
+	}
+
+	return p[i].route < p[j].route
+}
+
+// addPattern adds or replaces a pattern in the list, keeping the list in precedence order.
+func addPattern(patterns []routePattern, route string) []routePattern {
+	patterns = removePattern(patterns, route)
+	patterns = append(patterns, newRoutePattern(route))
+	sort.Sort(byPrecedence(patterns))
+	return patterns
+}
+
+func removePattern(patterns []routePattern, route string) []routePattern {
+	for i, p := range patterns {
+		if p.route == route {
+			return append(patterns[:i:i], patterns[i+1:]...)
+		}
+	}
+
+	return patterns
+}
+
+// matchPattern returns the first pattern in the list matching given method, and the captured segments.
+func matchPattern(patterns []routePattern, method string) (route string, captures map[string]string, ok bool) {
+	for _, p := range patterns {
+		if captures, ok := p.match(method); ok {
+			return p.route, captures, true
+		}
+	}
+
+	return "", nil, false
+}
@@ -0,0 +1,133 @@
+package jsonrpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/neptulon/cmap"
+)
+
+// Peer is one end of a JSON-RPC connection, which can both handle incoming messages and send outgoing ones, regardless of which side dialed the connection.
+// Server and client middleware create a Peer per connection, which is available to handlers as ctx.Peer,
+// so a handler can call back to the other end without passing connection IDs around.
+type Peer struct {
+	conn          Conn
+	mw            *Middleware
+	sender        Sender
+	router        *Router
+	heartbeat     heartbeat
+	heartbeatStop sync.Once
+	context       context.Context // canceled when the connection is forgotten, see Middleware.forgetConn
+	cancel        context.CancelFunc
+}
+
+// NewPeer creates a standalone peer over given connection, with a middleware stack of its own. Call Serve to start receiving messages.
+func NewPeer(conn Conn) *Peer {
+	return new(Middleware).peer(conn)
+}
+
+func newPeer(conn Conn, mw *Middleware) *Peer {
+	p := Peer{conn: conn, mw: mw, router: newRouter(), heartbeat: heartbeat{seen: time.Now().UnixNano(), stop: make(chan struct{})}}
+	p.context, p.cancel = context.WithCancel(context.Background())
+	p.sender = NewSender(mw, func(connID string, msg []byte) error { return p.conn.Send(msg) })
+	p.sender.registeredResponseMiddleware.Do(func() {}) // responses are dispatched to the peer directly by the middleware stack, see Middleware.handleMsg
+	return &p
+}
+
+// Serve receives messages from the connection until it is closed. Should only be called for peers created with NewPeer.
+// Returns nil if the connection was closed gracefully.
+func (p *Peer) Serve() error {
+	defer p.mw.forgetConn(p.conn.ConnID())
+	return p.mw.Serve(p.conn)
+}
+
+// ConnID is a randomly generated unique connection ID.
+func (p *Peer) ConnID() string {
+	return p.conn.ConnID()
+}
+
+// Session is a thread-safe data store for storing arbitrary data for this connection session.
+func (p *Peer) Session() *cmap.CMap {
+	return p.conn.Session()
+}
+
+// Context returns a context which is canceled when the connection is closed.
+func (p *Peer) Context() context.Context {
+	return p.context
+}
+
+// Middleware returns the middleware stack handling incoming messages of this peer, which is shared by all connections of a server.
+func (p *Peer) Middleware() *Middleware {
+	return p.mw
+}
+
+// Call sends a JSON-RPC request to the other end and blocks until the response arrives or given context is done.
+// See Sender.Call for details.
+func (p *Peer) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	return p.sender.Call(ctx, p.ConnID(), method, params, result)
+}
+
+// SendRequest sends a JSON-RPC request to the other end with an auto generated request ID.
+// resHandler is called when a response is returned.
+func (p *Peer) SendRequest(method string, params interface{}, resHandler func(ctx *ResCtx) error) (reqID string, err error) {
+	return p.sender.SendRequest(p.ConnID(), method, params, resHandler)
+}
+
+// SendRequestContext is the same as SendRequest, except that the message metadata carried by given context (if any) is sent along with the request.
+func (p *Peer) SendRequestContext(ctx context.Context, method string, params interface{}, resHandler func(ctx *ResCtx) error) (reqID string, err error) {
+	return p.sender.SendRequestContext(ctx, p.ConnID(), method, params, resHandler)
+}
+
+// SendNotification sends a JSON-RPC notification to the other end with structured params object.
+func (p *Peer) SendNotification(method string, params interface{}) error {
+	return p.sender.SendNotification(p.ConnID(), method, params)
+}
+
+// SendNotificationContext is the same as SendNotification, except that the message metadata carried by given context (if any) is sent along with the notification.
+func (p *Peer) SendNotificationContext(ctx context.Context, method string, params interface{}) error {
+	return p.sender.SendNotificationContext(ctx, p.ConnID(), method, params)
+}
+
+// HandleRequest registers a handler for incoming requests from this connection only.
+// Handlers registered on the middleware stack itself take precedence.
+func (p *Peer) HandleRequest(route string, handler func(ctx *ReqCtx) error, middleware ...func(ctx *ReqCtx) error) {
+	p.router.Request(route, handler, middleware...)
+}
+
+// HandleNotification registers a handler for incoming notifications from this connection only.
+// Handlers registered on the middleware stack itself take precedence.
+func (p *Peer) HandleNotification(route string, handler func(ctx *NotCtx) error, middleware ...func(ctx *NotCtx) error) {
+	p.router.Notification(route, handler, middleware...)
+}
+
+// Subscribe adds a listener for incoming notifications from this connection only. See Router.Subscribe for details.
+func (p *Peer) Subscribe(route string, listener func(ctx *NotCtx) error) (unsubscribe func()) {
+	return p.router.Subscribe(route, listener)
+}
+
+// Close closes the connection.
+func (p *Peer) Close() error {
+	return p.conn.Close()
+}
+
+// peer returns the peer for given connection, creating it on the first use.
+func (mw *Middleware) peer(c Conn) *Peer {
+	connID := c.ConnID()
+
+	mw.mutex.Lock()
+	if p, ok := mw.peers[connID]; ok {
+		mw.mutex.Unlock()
+		return p
+	}
+
+	if mw.peers == nil {
+		mw.peers = make(map[string]*Peer)
+	}
+	p := newPeer(c, mw)
+	mw.peers[connID] = p
+	mw.mutex.Unlock()
+
+	p.startHeartbeat()
+	return p
+}
@@ -0,0 +1,166 @@
+package jsonrpc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/neptulon/cmap"
+	"github.com/neptulon/shortid"
+)
+
+var errPipeClosed = errors.New("pipe is closed")
+
+// PipeServer is a JSON-RPC server accepting in-process connections over memory pipes, with no sockets involved.
+// Useful for testing middleware in isolation and for embedding a service within the same binary.
+type PipeServer struct {
+	Middleware
+	Sender
+	NotifyShutdown bool // Send ShutdownMethod notification to the connections on Shutdown.
+
+	mutex sync.RWMutex
+	conns map[string]*pipeConn // connection ID -> server end of the pipe
+}
+
+// NewPipeServer creates a JSON-RPC server accepting in-process connections.
+func NewPipeServer() *PipeServer {
+	s := PipeServer{conns: make(map[string]*pipeConn)}
+	s.Sender = NewSender(&s.Middleware, s.send)
+	return &s
+}
+
+// Dial creates a new in-process connection to the server and returns the client end of it, which starts receiving messages right away.
+func (s *PipeServer) Dial() (*Client, error) {
+	sc, cc, err := newPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	s.conns[sc.id] = sc
+	s.mutex.Unlock()
+
+	go func() {
+		s.Middleware.Serve(sc)
+
+		s.mutex.Lock()
+		delete(s.conns, sc.id)
+		s.mutex.Unlock()
+		s.forgetConn(sc.id)
+	}()
+
+	c := UseConn(cc)
+	go c.Serve(cc)
+	return c, nil
+}
+
+// Close closes all active connections.
+func (s *PipeServer) Close() error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, c := range s.conns {
+		c.Close()
+	}
+
+	return nil
+}
+
+func (s *PipeServer) send(connID string, msg []byte) error {
+	s.mutex.RLock()
+	c, ok := s.conns[connID]
+	s.mutex.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("connection not found: %v", connID)
+	}
+
+	return c.Send(msg)
+}
+
+// Pipe creates a synchronous, in-memory, full duplex connection and returns both ends of it as JSON-RPC Clients.
+// Both Clients start receiving messages right away.
+func Pipe() (*Client, *Client, error) {
+	c1, c2, err := newPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cl1, cl2 := UseConn(c1), UseConn(c2)
+	go cl1.Serve(c1)
+	go cl2.Serve(c2)
+	return cl1, cl2, nil
+}
+
+// pipeConn is one end of an in-memory connection. Each Send call blocks until the other end receives the message or the pipe is closed.
+type pipeConn struct {
+	id      string
+	session *cmap.CMap
+	in      chan []byte // incoming messages
+	peer    *pipeConn   // other end of the pipe
+	done    chan struct{}
+	once    *sync.Once // shared by both ends so closing either end closes the pipe
+}
+
+func newPipe() (*pipeConn, *pipeConn, error) {
+	id1, err := shortid.UUID()
+	if err != nil {
+		return nil, nil, err
+	}
+	id2, err := shortid.UUID()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	done := make(chan struct{})
+	once := &sync.Once{}
+	c1 := &pipeConn{id: id1, session: cmap.New(), in: make(chan []byte), done: done, once: once}
+	c2 := &pipeConn{id: id2, session: cmap.New(), in: make(chan []byte), done: done, once: once}
+	c1.peer, c2.peer = c2, c1
+	return c1, c2, nil
+}
+
+// ConnID is a randomly generated unique connection ID.
+func (c *pipeConn) ConnID() string {
+	return c.id
+}
+
+// Session is a thread-safe data store for storing arbitrary data for this connection session.
+func (c *pipeConn) Session() *cmap.CMap {
+	return c.session
+}
+
+// Send delivers a copy of the given message to the other end of the pipe.
+func (c *pipeConn) Send(msg []byte) error {
+	m := make([]byte, len(msg))
+	copy(m, msg)
+
+	select {
+	case c.peer.in <- m:
+		return nil
+	case <-c.done:
+		return errPipeClosed
+	}
+}
+
+// Close closes both ends of the pipe.
+func (c *pipeConn) Close() error {
+	err := errPipeClosed
+	c.once.Do(func() {
+		close(c.done)
+		err = nil
+	})
+
+	return err
+}
+
+// Receive blocks until the other end of the pipe sends a message.
+func (c *pipeConn) Receive() ([]byte, error) {
+	select {
+	case msg := <-c.in:
+		return msg, nil
+	case <-c.done:
+		return nil, io.EOF
+	}
+}
@@ -0,0 +1,346 @@
+package jsonrpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Balancing is a strategy for choosing the connection of a Pool to send a message through.
+type Balancing int
+
+const (
+	// RoundRobin uses the available connections in turn.
+	RoundRobin Balancing = iota
+	// LeastPending uses the connection with the least number of requests waiting for a response.
+	LeastPending
+)
+
+// DefaultPoolRetryInterval is the default time to wait before re-dialing a failed connection of a Pool.
+const DefaultPoolRetryInterval = 5 * time.Second
+
+// Dialer establishes a new client connection, i.e. PipeServer.Dial or NeptulonDialer("127.0.0.1:3000").
+type Dialer func() (*Client, error)
+
+// NeptulonDialer returns a Dialer connecting to the Neptulon server at given network address.
+func NeptulonDialer(addr string) Dialer {
+	return func() (*Client, error) {
+		c := NewClient(nil, nil)
+		if err := c.Connect(addr, false); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+}
+
+// Pool is a client which spreads outgoing messages across several connections to one or more servers.
+// Connections that fail to send a message or get disconnected are taken out of the pool, closed, and re-dialed in the background until they succeed.
+// Requests waiting for a response from such a connection fail with ConnLostCode error.
+// Middleware registered on the pool are registered on all of its connections, including the re-dialed ones.
+type Pool struct {
+	Balancing     Balancing     // Strategy for choosing connections. Default is RoundRobin.
+	RetryInterval time.Duration // Time to wait before re-dialing a failed connection. Default is DefaultPoolRetryInterval.
+
+	mutex   sync.Mutex
+	members []*poolMember
+	next    int // next member index for round-robin
+	closed  bool
+	mw      connMiddleware
+}
+
+type poolMember struct {
+	dial    Dialer
+	client  *Client // nil while the connection is down
+	pending int     // number of requests waiting for a response
+	gen     int     // incremented on every eviction, so the requests of an evicted connection do not count against the next one
+}
+
+// NewPool creates a pool with one connection per given dialer. Pass the same dialer multiple times for multiple connections to the same server.
+// Connections that cannot be established right away are retried in the background. Returns an error only if none of the connections can be established.
+func NewPool(dialers ...Dialer) (*Pool, error) {
+	if len(dialers) == 0 {
+		return nil, errors.New("at least one dialer is required")
+	}
+
+	p := Pool{}
+	var lastErr error
+	for _, d := range dialers {
+		m := &poolMember{dial: d}
+		p.members = append(p.members, m)
+		if m.client, lastErr = d(); lastErr != nil {
+			go p.redial(m)
+			continue
+		}
+		p.watch(m, m.client)
+	}
+
+	if p.available() == 0 {
+		p.Close()
+		return nil, lastErr
+	}
+
+	return &p, nil
+}
+
+// Call sends a JSON-RPC request through one of the connections and blocks until the response arrives or given context is done.
+// See Sender.Call for details.
+func (p *Pool) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	var forget func()
+	return call(ctx, result, func(resHandler func(ctx *ResCtx) error) (reqID string, err error) {
+		reqID, forget, err = p.sendRequest(ctx, method, params, resHandler)
+		return reqID, err
+	}, func(reqID string) { forget() })
+}
+
+// SendRequest sends a JSON-RPC request through one of the connections with an auto generated request ID.
+// resHandler is called when a response is returned.
+func (p *Pool) SendRequest(method string, params interface{}, resHandler func(ctx *ResCtx) error) (reqID string, err error) {
+	return p.SendRequestContext(context.Background(), method, params, resHandler)
+}
+
+// SendRequestContext is the same as SendRequest, except that the message metadata carried by given context (if any) is sent along with the request.
+func (p *Pool) SendRequestContext(ctx context.Context, method string, params interface{}, resHandler func(ctx *ResCtx) error) (reqID string, err error) {
+	reqID, _, err = p.sendRequest(ctx, method, params, resHandler)
+	return reqID, err
+}
+
+// SendNotification sends a JSON-RPC notification through one of the connections with structured params object.
+func (p *Pool) SendNotification(method string, params interface{}) error {
+	return p.SendNotificationContext(context.Background(), method, params)
+}
+
+// SendNotificationContext is the same as SendNotification, except that the message metadata carried by given context (if any) is sent along with the notification.
+func (p *Pool) SendNotificationContext(ctx context.Context, method string, params interface{}) error {
+	for {
+		m, c, err := p.pick()
+		if err != nil {
+			return err
+		}
+		if err := c.SendNotificationContext(ctx, method, params); err != nil {
+			p.evict(m, c)
+			continue
+		}
+		return nil
+	}
+}
+
+// ReqMiddleware registers middleware to handle request messages on all connections.
+func (p *Pool) ReqMiddleware(reqMiddleware ...func(ctx *ReqCtx) error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.mw.req = append(p.mw.req, reqMiddleware...)
+	for _, m := range p.members {
+		if m.client != nil {
+			m.client.ReqMiddleware(reqMiddleware...)
+		}
+	}
+}
+
+// NotMiddleware registers middleware to handle notification messages on all connections.
+func (p *Pool) NotMiddleware(notMiddleware ...func(ctx *NotCtx) error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.mw.not = append(p.mw.not, notMiddleware...)
+	for _, m := range p.members {
+		if m.client != nil {
+			m.client.NotMiddleware(notMiddleware...)
+		}
+	}
+}
+
+// ResMiddleware registers middleware to handle response messages on all connections.
+func (p *Pool) ResMiddleware(resMiddleware ...func(ctx *ResCtx) error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.mw.res = append(p.mw.res, resMiddleware...)
+	for _, m := range p.members {
+		if m.client != nil {
+			m.client.ResMiddleware(resMiddleware...)
+		}
+	}
+}
+
+// Close closes all the connections and stops re-dialing the failed ones.
+func (p *Pool) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.closed = true
+	var err error
+	for _, m := range p.members {
+		if m.client != nil {
+			if cerr := m.client.Close(); cerr != nil {
+				err = cerr
+			}
+			m.client = nil
+		}
+	}
+
+	return err
+}
+
+// sendRequest sends the request through the chosen connection, moving on to the next one if sending fails.
+// Returned forget function stops waiting for the response.
+func (p *Pool) sendRequest(ctx context.Context, method string, params interface{}, resHandler func(ctx *ResCtx) error) (reqID string, forget func(), err error) {
+	for {
+		m, c, err := p.pick()
+		if err != nil {
+			return "", nil, err
+		}
+
+		p.mutex.Lock()
+		m.pending++
+		gen := m.gen
+		p.mutex.Unlock()
+
+		var once sync.Once
+		done := func() {
+			once.Do(func() {
+				p.mutex.Lock()
+				if m.gen == gen {
+					m.pending--
+				}
+				p.mutex.Unlock()
+			})
+		}
+
+		id, err := c.SendRequestContext(ctx, method, params, func(ctx *ResCtx) error {
+			done()
+			return resHandler(ctx)
+		})
+		if err != nil {
+			done()
+			p.evict(m, c)
+			continue
+		}
+
+		return id, func() {
+			done()
+			c.sender.forgetRequest(id)
+		}, nil
+	}
+}
+
+// pick chooses an available connection based on the balancing strategy.
+func (p *Pool) pick() (*poolMember, *Client, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.closed {
+		return nil, nil, errors.New("pool is closed")
+	}
+
+	// start from the next member in turn, so least pending strategy also spreads messages across equally loaded connections
+	start := p.next
+	p.next = (p.next + 1) % len(p.members)
+
+	var pick *poolMember
+	for i := range p.members {
+		m := p.members[(start+i)%len(p.members)]
+		if m.client == nil {
+			continue
+		}
+		if pick == nil || m.pending < pick.pending {
+			pick = m
+		}
+		if p.Balancing == RoundRobin {
+			break
+		}
+	}
+
+	if pick == nil {
+		return nil, nil, errors.New("no connections available in the pool")
+	}
+
+	return pick, pick.client, nil
+}
+
+func (p *Pool) available() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	n := 0
+	for _, m := range p.members {
+		if m.client != nil {
+			n++
+		}
+	}
+
+	return n
+}
+
+// evict takes the failed connection out of the pool and starts re-dialing it.
+// Requests waiting for a response from the connection fail with ConnLostCode error.
+func (p *Pool) evict(m *poolMember, c *Client) {
+	p.mutex.Lock()
+	if m.client != c { // already evicted by another sender
+		p.mutex.Unlock()
+		return
+	}
+	m.client = nil
+	m.pending = 0
+	m.gen++
+	p.mutex.Unlock()
+
+	c.Close()
+	c.sender.failPending(c)
+	go p.redial(m)
+}
+
+// watch evicts the connection of a pool member once it is disconnected (i.e. closed by the server), rather than waiting for a send to fail.
+func (p *Pool) watch(m *poolMember, c *Client) {
+	go func() {
+		<-c.Done()
+		p.evict(m, c)
+	}()
+}
+
+// redial re-establishes the connection of a pool member every retry interval, until it succeeds or the pool is closed.
+func (p *Pool) redial(m *poolMember) {
+	for {
+		p.mutex.Lock()
+		interval, closed := p.RetryInterval, p.closed
+		p.mutex.Unlock()
+		if interval == 0 {
+			interval = DefaultPoolRetryInterval
+		}
+		if closed {
+			return
+		}
+
+		time.Sleep(interval)
+		c, err := m.dial()
+		if err != nil {
+			continue
+		}
+
+		p.mutex.Lock()
+		if p.closed {
+			p.mutex.Unlock()
+			c.Close()
+			return
+		}
+		p.mw.register(c)
+		m.client = c
+		p.mutex.Unlock()
+		p.watch(m, c)
+		return
+	}
+}
+
+// connMiddleware records the middleware registered on a group of connections, to be registered on the new connections as well.
+type connMiddleware struct {
+	req []func(ctx *ReqCtx) error
+	not []func(ctx *NotCtx) error
+	res []func(ctx *ResCtx) error
+}
+
+func (m *connMiddleware) register(c *Client) {
+	c.ReqMiddleware(m.req...)
+	c.NotMiddleware(m.not...)
+	c.ResMiddleware(m.res...)
+}
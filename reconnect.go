@@ -0,0 +1,440 @@
+package jsonrpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/neptulon/cmap"
+	"github.com/neptulon/shortid"
+)
+
+// ConnLostCode is the error code of the responses generated locally for requests which were in flight when the connection was lost.
+const ConnLostCode = -32000
+
+// Default backoff limits of ReconnectingClient.
+const (
+	DefaultMinBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff = 30 * time.Second
+)
+
+var errReconnecting = errors.New("jsonrpc: connection is lost, reconnecting")
+
+// ReconnectingClient is a client which re-dials its connection with exponential backoff whenever it is lost.
+// Setup hooks (i.e. login, resubscribe) are run on every new connection before it is put into use.
+// Requests to idempotent methods which are in flight when the connection is lost are re-sent over the new connection,
+// and are queued to be sent if the connection is not available at the moment. Other requests fail with ConnLostCode error.
+// Middleware registered on the client are registered on all of its connections.
+type ReconnectingClient struct {
+	MinBackoff time.Duration // Initial time to wait before re-dialing. Default is DefaultMinBackoff.
+	MaxBackoff time.Duration // Maximum time to wait before re-dialing. Default is DefaultMaxBackoff.
+
+	dial       Dialer
+	mutex      sync.Mutex
+	client     *Client // nil while reconnecting
+	setup      *Client // new connection running the setup hooks, which is not in use yet
+	closed     bool
+	hooks      []func(c *Client) error
+	idempotent map[string]bool            // method name -> true
+	pending    map[string]*pendingRequest // request key -> request waiting for a response
+	mw         connMiddleware
+}
+
+type pendingRequest struct {
+	method     string
+	params     interface{}
+	meta       map[string]string
+	resHandler func(ctx *ResCtx) error
+	client     *Client // connection the request was sent through, nil if queued
+	id         string  // request ID on the connection
+}
+
+// NewReconnectingClient dials the first connection and returns the client, or returns the dialing error.
+// Use NeptulonDialer to connect to a Neptulon server.
+func NewReconnectingClient(dial Dialer) (*ReconnectingClient, error) {
+	c, err := dial()
+	if err != nil {
+		return nil, err
+	}
+
+	rc := ReconnectingClient{
+		dial:       dial,
+		idempotent: make(map[string]bool),
+		pending:    make(map[string]*pendingRequest),
+	}
+	rc.use(c)
+	return &rc, nil
+}
+
+// OnConnect registers a setup hook which is run on the current connection right away, and on every new connection after reconnecting.
+// If a hook returns an error on a new connection, the connection is dropped and dialed again.
+func (rc *ReconnectingClient) OnConnect(hook func(c *Client) error) error {
+	rc.mutex.Lock()
+	rc.hooks = append(rc.hooks, hook)
+	c := rc.client
+	rc.mutex.Unlock()
+
+	if c == nil {
+		return nil
+	}
+
+	return hook(c)
+}
+
+// Idempotent marks the given methods as safe to be re-sent when the connection is lost before a response arrives.
+func (rc *ReconnectingClient) Idempotent(methods ...string) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	for _, m := range methods {
+		rc.idempotent[m] = true
+	}
+}
+
+// Client returns the current connection, or nil if the client is reconnecting.
+func (rc *ReconnectingClient) Client() *Client {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	return rc.client
+}
+
+// Call sends a JSON-RPC request and blocks until the response arrives or given context is done.
+// See Sender.Call for details.
+func (rc *ReconnectingClient) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	return call(ctx, result, func(resHandler func(ctx *ResCtx) error) (string, error) {
+		return rc.SendRequestContext(ctx, method, params, resHandler)
+	}, rc.forget)
+}
+
+// SendRequest sends a JSON-RPC request with an auto generated request ID.
+// resHandler is called when a response is returned, or with a ConnLostCode error if the connection is lost before that.
+// ctx.Client of such a ConnLostCode error response is the lost connection, or nil if the request was queued while reconnecting and never sent.
+// Returned request ID stays the same even if the request is re-sent over a new connection.
+func (rc *ReconnectingClient) SendRequest(method string, params interface{}, resHandler func(ctx *ResCtx) error) (reqID string, err error) {
+	return rc.SendRequestContext(context.Background(), method, params, resHandler)
+}
+
+// SendRequestContext is the same as SendRequest, except that the message metadata carried by given context (if any) is sent along with the request,
+// including the re-sent ones.
+func (rc *ReconnectingClient) SendRequestContext(ctx context.Context, method string, params interface{}, resHandler func(ctx *ResCtx) error) (reqID string, err error) {
+	key, err := shortid.UUID()
+	if err != nil {
+		return "", err
+	}
+
+	req := &pendingRequest{method: method, params: params, meta: MetaFromContext(ctx), resHandler: resHandler}
+	rc.mutex.Lock()
+	if rc.closed {
+		rc.mutex.Unlock()
+		return "", errors.New("jsonrpc: client is closed")
+	}
+	c, idempotent := rc.client, rc.idempotent[method]
+	if c == nil && !idempotent {
+		rc.mutex.Unlock()
+		return "", errReconnecting
+	}
+	req.client = c
+	rc.pending[key] = req
+	rc.mutex.Unlock()
+
+	if c == nil {
+		return key, nil // queued until reconnected
+	}
+
+	if err := rc.send(key, req, c); err != nil {
+		if !idempotent {
+			rc.mutex.Lock()
+			delete(rc.pending, key)
+			rc.mutex.Unlock()
+			return "", err
+		}
+		rc.requeue(key, req, c)
+	}
+
+	return key, nil
+}
+
+// SendNotification sends a JSON-RPC notification with structured params object.
+func (rc *ReconnectingClient) SendNotification(method string, params interface{}) error {
+	return rc.SendNotificationContext(context.Background(), method, params)
+}
+
+// SendNotificationContext is the same as SendNotification, except that the message metadata carried by given context (if any) is sent along with the notification.
+func (rc *ReconnectingClient) SendNotificationContext(ctx context.Context, method string, params interface{}) error {
+	c := rc.Client()
+	if c == nil {
+		return errReconnecting
+	}
+
+	if err := c.SendNotificationContext(ctx, method, params); err != nil {
+		rc.lost(c)
+		return err
+	}
+
+	return nil
+}
+
+// ReqMiddleware registers middleware to handle request messages on all connections.
+func (rc *ReconnectingClient) ReqMiddleware(reqMiddleware ...func(ctx *ReqCtx) error) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	rc.mw.req = append(rc.mw.req, reqMiddleware...)
+	if rc.client != nil {
+		rc.client.ReqMiddleware(reqMiddleware...)
+	}
+	if rc.setup != nil {
+		rc.setup.ReqMiddleware(reqMiddleware...)
+	}
+}
+
+// NotMiddleware registers middleware to handle notification messages on all connections.
+func (rc *ReconnectingClient) NotMiddleware(notMiddleware ...func(ctx *NotCtx) error) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	rc.mw.not = append(rc.mw.not, notMiddleware...)
+	if rc.client != nil {
+		rc.client.NotMiddleware(notMiddleware...)
+	}
+	if rc.setup != nil {
+		rc.setup.NotMiddleware(notMiddleware...)
+	}
+}
+
+// ResMiddleware registers middleware to handle response messages on all connections.
+func (rc *ReconnectingClient) ResMiddleware(resMiddleware ...func(ctx *ResCtx) error) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	rc.mw.res = append(rc.mw.res, resMiddleware...)
+	if rc.client != nil {
+		rc.client.ResMiddleware(resMiddleware...)
+	}
+	if rc.setup != nil {
+		rc.setup.ResMiddleware(resMiddleware...)
+	}
+}
+
+// Close closes the connection and stops reconnecting. Pending requests fail with ConnLostCode error.
+func (rc *ReconnectingClient) Close() error {
+	rc.mutex.Lock()
+	rc.closed = true
+	c := rc.client
+	rc.mutex.Unlock()
+
+	if c == nil {
+		rc.failPending(nil)
+		return nil
+	}
+
+	err := c.Close()
+	rc.lost(c)
+	return err
+}
+
+// send sends the pending request through given connection, which should already be assigned to req.client by the caller.
+// If sending fails, the connection is considered lost.
+func (rc *ReconnectingClient) send(key string, req *pendingRequest, c *Client) error {
+	id, err := shortid.UUID()
+	if err != nil {
+		return err
+	}
+
+	// ID is assigned before sending, as the response or a re-send after reconnecting might come before sending returns
+	rc.mutex.Lock()
+	req.id = id
+	rc.mutex.Unlock()
+
+	err = c.sender.sendRequest(ContextWithMeta(context.Background(), req.meta), "", id, req.method, req.params, func(ctx *ResCtx) error {
+		rc.mutex.Lock()
+		_, ok := rc.pending[key]
+		delete(rc.pending, key)
+		rc.mutex.Unlock()
+
+		if !ok {
+			return ctx.Next()
+		}
+		ctx.id = key
+		return req.resHandler(ctx)
+	})
+	if err != nil {
+		rc.lost(c)
+		return err
+	}
+
+	return nil
+}
+
+// requeue re-sends an idempotent request that could not be sent through given lost connection over the new connection (if any),
+// or queues it to be re-sent after reconnecting.
+func (rc *ReconnectingClient) requeue(key string, req *pendingRequest, lost *Client) {
+	rc.mutex.Lock()
+	if _, ok := rc.pending[key]; !ok || req.client != lost {
+		rc.mutex.Unlock()
+		return // answered, forgotten, or already re-sent
+	}
+	req.client = rc.client
+	if req.client == lost {
+		req.client = nil
+	}
+	c := req.client
+	rc.mutex.Unlock()
+
+	if c != nil {
+		if err := rc.send(key, req, c); err != nil {
+			rc.requeue(key, req, c)
+		}
+	}
+}
+
+// forget stops waiting for the response of the request.
+func (rc *ReconnectingClient) forget(key string) {
+	rc.mutex.Lock()
+	req, ok := rc.pending[key]
+	delete(rc.pending, key)
+	var c *Client
+	var id string
+	if ok {
+		c, id = req.client, req.id
+	}
+	rc.mutex.Unlock()
+
+	if c != nil {
+		c.sender.forgetRequest(id)
+	}
+}
+
+// use puts the connection into use and starts watching it for disconnection. Middleware should already be registered on the connection.
+func (rc *ReconnectingClient) use(c *Client) {
+	rc.client = c
+
+	go func() {
+		<-c.Done()
+		rc.lost(c)
+	}()
+}
+
+// lost handles the loss of given connection, failing the pending non-idempotent requests and starting to reconnect.
+func (rc *ReconnectingClient) lost(c *Client) {
+	rc.mutex.Lock()
+	if rc.client != c {
+		rc.mutex.Unlock()
+		return // already handled
+	}
+	rc.client = nil
+	closed := rc.closed
+	rc.mutex.Unlock()
+
+	c.Close()
+	if closed {
+		rc.failPending(nil)
+		return
+	}
+
+	rc.failPending(rc.idempotent)
+	go rc.reconnect()
+}
+
+// failPending answers the pending requests, except the given idempotent ones, with ConnLostCode error.
+// ctx.Client of the responses is the connection the request was sent through, which is nil for the requests that were queued while reconnecting and never sent.
+func (rc *ReconnectingClient) failPending(idempotent map[string]bool) {
+	var failed []*pendingRequest
+	var keys []string
+	rc.mutex.Lock()
+	for key, req := range rc.pending {
+		if idempotent[req.method] {
+			req.client = nil
+			continue
+		}
+		delete(rc.pending, key)
+		failed = append(failed, req)
+		keys = append(keys, key)
+	}
+	rc.mutex.Unlock()
+
+	for i, req := range failed {
+		err := &resError{Code: ConnLostCode, Message: "Connection lost before the response was received"}
+		req.resHandler(newResCtx(keys[i], nil, err, req.client, nil, cmap.New(), JSON))
+	}
+}
+
+// reconnect dials a new connection with exponential backoff, runs the setup hooks on it, and re-sends the idempotent requests.
+func (rc *ReconnectingClient) reconnect() {
+	backoff := rc.MinBackoff
+	if backoff == 0 {
+		backoff = DefaultMinBackoff
+	}
+	max := rc.MaxBackoff
+	if max == 0 {
+		max = DefaultMaxBackoff
+	}
+
+	for {
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > max {
+			backoff = max
+		}
+
+		rc.mutex.Lock()
+		closed := rc.closed
+		hooks := rc.hooks
+		rc.mutex.Unlock()
+		if closed {
+			return
+		}
+
+		c, err := rc.dial()
+		if err != nil {
+			continue
+		}
+
+		// middleware are registered before the hooks run, so the messages exchanged by the hooks go through them as well
+		rc.mutex.Lock()
+		rc.mw.register(c)
+		rc.setup = c
+		rc.mutex.Unlock()
+
+		err = runHooks(c, hooks)
+		rc.mutex.Lock()
+		rc.setup = nil
+		if err != nil {
+			rc.mutex.Unlock()
+			c.Close()
+			continue
+		}
+		if rc.closed {
+			rc.mutex.Unlock()
+			c.Close()
+			return
+		}
+		rc.use(c)
+		queued := make(map[string]*pendingRequest)
+		for key, req := range rc.pending {
+			if req.client == nil {
+				req.client = c
+				queued[key] = req
+			}
+		}
+		rc.mutex.Unlock()
+
+		for key, req := range queued {
+			if err := rc.send(key, req, c); err != nil {
+				rc.requeue(key, req, c)
+			}
+		}
+		return
+	}
+}
+
+func runHooks(c *Client, hooks []func(c *Client) error) error {
+	for _, hook := range hooks {
+		if err := hook(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,51 +1,317 @@
 package jsonrpc
 
-import "errors"
+import (
+	"errors"
+	"log"
+	"strings"
+	"sync"
+)
 
 // Router is a JSON-RPC message routing middleware.
+// Routers can be mounted onto other routers under a method name prefix (namespace), each with its own middleware stack. See Group and Mount.
 type Router struct {
-	reqRoutes map[string]func(ctx *ReqCtx) error // method name -> handler func(ctx *ReqCtx) error
-	notRoutes map[string]func(ctx *NotCtx) error // method name -> handler func(ctx *NotCtx) error
+	Info OpenRPCInfo // Service metadata returned in rpc.discover document.
+
+	mutex         sync.RWMutex                       // guards the routes, mounts, and middleware stacks, which can be registered while serving (i.e. on a Peer)
+	reqRoutes     map[string]func(ctx *ReqCtx) error // method name -> handler func(ctx *ReqCtx) error
+	notRoutes     map[string]func(ctx *NotCtx) error // method name -> handler func(ctx *NotCtx) error
+	notListeners  map[string][]*notListener          // method name -> notification listeners, see Router.Subscribe
+	reqTypes      map[string]methodTypes             // method name -> params and result types of typed request handlers
+	notTypes      map[string]methodTypes             // method name -> params type of typed notification handlers
+	reqPatterns   []routePattern                     // request routes with wildcard segments, in precedence order
+	notPatterns   []routePattern                     // notification routes with wildcard segments, in precedence order
+	mounts        map[string]*Router                 // method name prefix -> mounted sub-router
+	nested        []*Router                          // routers registered as middleware of this mounted router, which are included in Discover
+	reqMiddleware []func(ctx *ReqCtx) error          // request middleware of a mounted router, see Router.ReqMiddleware
+	notMiddleware []func(ctx *NotCtx) error          // notification middleware of a mounted router, see Router.NotMiddleware
 }
 
 // NewRouter creates a JSON-RPC router instance and registers it as a Neptulon JSON-RPC middleware.
-func NewRouter(m MiddlewareHandler) (*Router, error) {
+func NewRouter(m RouteHandler) (*Router, error) {
 	if m == nil {
 		return nil, errors.New("given JSON-RPC Middleware instance is nil")
 	}
 
-	r := Router{
-		reqRoutes: make(map[string]func(ctx *ReqCtx) error),
-		notRoutes: make(map[string]func(ctx *NotCtx) error),
+	r := newRouter()
+	m.ReqMiddleware(r.routeReq)
+	m.NotMiddleware(r.routeNot)
+	if parent, ok := m.(*Router); ok {
+		parent.mutex.Lock()
+		parent.nested = append(parent.nested, r)
+		parent.mutex.Unlock()
 	}
+	return r, nil
+}
 
-	m.ReqMiddleware(r.reqMiddleware)
-	m.NotMiddleware(r.notMiddleware)
-	return &r, nil
+func newRouter() *Router {
+	return &Router{
+		Info:         OpenRPCInfo{Title: "JSON-RPC", Version: "1.0.0"},
+		reqRoutes:    make(map[string]func(ctx *ReqCtx) error),
+		notRoutes:    make(map[string]func(ctx *NotCtx) error),
+		notListeners: make(map[string][]*notListener),
+		reqTypes:     make(map[string]methodTypes),
+		notTypes:     make(map[string]methodTypes),
+		mounts:       make(map[string]*Router),
+	}
 }
 
 // Request adds a new request route registry.
-func (r *Router) Request(route string, handler func(ctx *ReqCtx) error) {
+// Route can be a pattern with wildcard segments, i.e. "chat.*" or "events.{room}.post", where captured segments are available through ctx.Capture.
+// A route of "*" is a catch-all, handling all the requests not handled by the other routes of the router, except for rpc.discover.
+// Exact routes take precedence over mounted routers (see Mount), which take precedence over patterns.
+// Among the patterns, literal segments take precedence over captures, which take precedence over wildcards, comparing the segments from the start.
+// Optional middleware runs only for this route, before the handler. Each middleware calls ctx.Next() to pass the request on,
+// and ctx.Next() in the handler continues with the middleware stack that the router is registered on.
+func (r *Router) Request(route string, handler func(ctx *ReqCtx) error, middleware ...func(ctx *ReqCtx) error) {
+	if len(middleware) != 0 {
+		stack := append(middleware[:len(middleware):len(middleware)], handler)
+		handler = func(ctx *ReqCtx) error { return ctx.nest(stack, ctx.route) }
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
 	r.reqRoutes[route] = handler
+	delete(r.reqTypes, route)
+	if isPattern(route) {
+		r.reqPatterns = addPattern(r.reqPatterns, route)
+	}
 }
 
 // Notification adds a new notification route registry.
-func (r *Router) Notification(route string, handler func(ctx *NotCtx) error) {
+// Route can be a pattern with wildcard segments, and optional middleware runs only for this route, before the handler. See Request for details.
+func (r *Router) Notification(route string, handler func(ctx *NotCtx) error, middleware ...func(ctx *NotCtx) error) {
+	if len(middleware) != 0 {
+		stack := append(middleware[:len(middleware):len(middleware)], handler)
+		handler = func(ctx *NotCtx) error { return ctx.nest(stack, ctx.route) }
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
 	r.notRoutes[route] = handler
+	delete(r.notTypes, route)
+	if isPattern(route) {
+		r.notPatterns = addPattern(r.notPatterns, route)
+	}
+}
+
+// notListener is a notification listener. It is a pointer so that it can be removed from the listeners of a route by identity.
+type notListener struct {
+	handler func(ctx *NotCtx) error
+}
+
+// Subscribe adds a listener for the notifications with given route, which can be a pattern (see Request).
+// Unlike Notification, which replaces the earlier handler of the route, any number of listeners can be added to the same route.
+// Listeners are called in the order they are added, before the handler registered with Notification (if any).
+// Each listener is isolated from the others and the rest of the middleware stack: ctx.Next() has no effect in a listener,
+// and errors returned by (or panics in) a listener are logged without stopping the other listeners. Call the returned function to remove the listener.
+func (r *Router) Subscribe(route string, listener func(ctx *NotCtx) error) (unsubscribe func()) {
+	l := &notListener{handler: listener}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// listener slices are never modified in place so they can be called without holding the mutex
+	listeners := r.notListeners[route]
+	r.notListeners[route] = append(listeners[:len(listeners):len(listeners)], l)
+	if isPattern(route) {
+		r.notPatterns = addPattern(r.notPatterns, route)
+	}
+
+	return func() { r.unsubscribe(route, l) }
+}
+
+func (r *Router) unsubscribe(route string, l *notListener) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	listeners := r.notListeners[route]
+	for i, li := range listeners {
+		if li == l {
+			listeners = append(listeners[:i:i], listeners[i+1:]...)
+			break
+		}
+	}
+
+	if len(listeners) != 0 {
+		r.notListeners[route] = listeners
+		return
+	}
+
+	delete(r.notListeners, route)
+	if _, ok := r.notRoutes[route]; !ok {
+		r.notPatterns = removePattern(r.notPatterns, route)
+	}
+}
+
+// notifyListeners calls the listeners with a copy of the context, which has an empty middleware stack.
+func notifyListeners(ctx *NotCtx, listeners []*notListener) {
+	for _, l := range listeners {
+		notifyListener(ctx, l)
+	}
+}
+
+// notifyListener calls a single listener, logging the error or the panic (if any) so that the other listeners still run.
+func notifyListener(ctx *NotCtx, l *notListener) {
+	defer func() {
+		if v := recover(); v != nil {
+			log.Printf("jsonrpc: panic in listener of notification %v: %v", ctx.method, v)
+		}
+	}()
+
+	lctx := *ctx
+	lctx.mw, lctx.mwIndex = nil, 0
+	if err := l.handler(&lctx); err != nil {
+		log.Printf("jsonrpc: error in listener of notification %v: %v", ctx.method, err)
+	}
+}
+
+// Group creates a sub-router for the methods starting with given prefix (i.e. "users.") and mounts it onto this router.
+// Routes of the sub-router are registered without the prefix (i.e. "get" for "users.get"). See Mount for details.
+func (r *Router) Group(prefix string) *Router {
+	sub := newRouter()
+	r.Mount(prefix, sub)
+	return sub
+}
+
+// Mount routes the messages with methods starting with given prefix to the given sub-router, which handles them with the prefix trimmed.
+// Messages are passed through the middleware stack of the sub-router before its routes, so middleware can be applied to a single namespace.
+// Routes registered on this router directly take precedence over the mounted routers, and the longest matching prefix wins among the mounted routers.
+// Messages that the sub-router does not handle continue down the middleware stack of this router. Mounting nil removes the sub-router at given prefix.
+func (r *Router) Mount(prefix string, sub *Router) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if sub == nil {
+		delete(r.mounts, prefix)
+		return
+	}
+	r.mounts[prefix] = sub
+}
+
+// ReqMiddleware registers middleware to handle the request messages routed to this router, before they reach the routes.
+// Only takes effect for routers mounted onto other routers (see Group and Mount). ctx.Method() returns the full method name including the prefix,
+// while the routers registered as middleware (i.e. with NewRouter) route the method name relative to the prefix.
+func (r *Router) ReqMiddleware(reqMiddleware ...func(ctx *ReqCtx) error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.reqMiddleware = append(r.reqMiddleware, reqMiddleware...)
+}
+
+// NotMiddleware registers middleware to handle the notification messages routed to this router, before they reach the routes.
+// Only takes effect for routers mounted onto other routers (see Group and Mount). See ReqMiddleware for method names.
+func (r *Router) NotMiddleware(notMiddleware ...func(ctx *NotCtx) error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.notMiddleware = append(r.notMiddleware, notMiddleware...)
 }
 
-func (r *Router) reqMiddleware(ctx *ReqCtx) error {
-	if handler, ok := r.reqRoutes[ctx.method]; ok {
+// mounted returns the sub-router mounted with the longest prefix matching given route, and the route with the prefix trimmed.
+// Should be called with the mutex locked.
+func (r *Router) mounted(route string) (*Router, string) {
+	var match string
+	var sub *Router
+	for prefix, s := range r.mounts {
+		if strings.HasPrefix(route, prefix) && (sub == nil || len(prefix) > len(match)) {
+			match, sub = prefix, s
+		}
+	}
+
+	return sub, strings.TrimPrefix(route, match)
+}
+
+func (r *Router) routeReq(ctx *ReqCtx) error {
+	return r.handleReq(ctx, ctx.route)
+}
+
+// handleReq routes a request with given route, which is the method name relative to the prefix of this router.
+func (r *Router) handleReq(ctx *ReqCtx, route string) error {
+	r.mutex.RLock()
+	handler, ok := r.reqRoutes[route]
+	ok = ok && !isPattern(route) // patterns are only matched after the mounted routers
+	sub, subRoute := r.mounted(route)
+	var captures map[string]string
+	if !ok && sub == nil && ctx.method != discoverMethod {
+		var pattern string
+		if pattern, captures, ok = matchPattern(r.reqPatterns, route); ok {
+			handler = r.reqRoutes[pattern]
+		}
+	}
+	r.mutex.RUnlock()
+
+	if ok {
+		ctx.captures, ctx.handled = mergeCaptures(ctx.captures, captures), true
 		return handler(ctx)
 	}
 
+	if sub != nil {
+		return sub.serveReq(ctx, subRoute)
+	}
+
+	if ctx.method == discoverMethod {
+		return r.discover(ctx)
+	}
+
 	return ctx.Next()
 }
 
-func (r *Router) notMiddleware(ctx *NotCtx) error {
-	if handler, ok := r.notRoutes[ctx.method]; ok {
+// serveReq passes a request through the middleware stack and the routes of this mounted router, and then resumes the middleware stack of the parent.
+func (r *Router) serveReq(ctx *ReqCtx, route string) error {
+	r.mutex.RLock()
+	mw := r.reqMiddleware[:len(r.reqMiddleware):len(r.reqMiddleware)]
+	r.mutex.RUnlock()
+
+	return ctx.nest(append(mw, func(ctx *ReqCtx) error {
+		return r.handleReq(ctx, route)
+	}), route)
+}
+
+func (r *Router) routeNot(ctx *NotCtx) error {
+	return r.handleNot(ctx, ctx.route)
+}
+
+// handleNot routes a notification with given route, which is the method name relative to the prefix of this router.
+func (r *Router) handleNot(ctx *NotCtx, route string) error {
+	r.mutex.RLock()
+	handler, ok := r.notRoutes[route]
+	listeners := r.notListeners[route]
+	ok = (ok || len(listeners) != 0) && !isPattern(route) // patterns are only matched after the mounted routers
+	sub, subRoute := r.mounted(route)
+	var captures map[string]string
+	if !ok && sub == nil {
+		var pattern string
+		if pattern, captures, ok = matchPattern(r.notPatterns, route); ok {
+			handler, listeners = r.notRoutes[pattern], r.notListeners[pattern]
+		}
+	}
+	r.mutex.RUnlock()
+
+	if ok {
+		ctx.captures, ctx.handled = mergeCaptures(ctx.captures, captures), true
+		notifyListeners(ctx, listeners)
+		if handler == nil {
+			return ctx.Next()
+		}
 		return handler(ctx)
 	}
 
+	if sub != nil {
+		return sub.serveNot(ctx, subRoute)
+	}
+
 	return ctx.Next()
 }
+
+// serveNot passes a notification through the middleware stack and the routes of this mounted router, and then resumes the middleware stack of the parent.
+func (r *Router) serveNot(ctx *NotCtx, route string) error {
+	r.mutex.RLock()
+	mw := r.notMiddleware[:len(r.notMiddleware):len(r.notMiddleware)]
+	r.mutex.RUnlock()
+
+	return ctx.nest(append(mw, func(ctx *NotCtx) error {
+		return r.handleNot(ctx, route)
+	}), route)
+}
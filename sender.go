@@ -1,7 +1,8 @@
 package jsonrpc
 
 import (
-	"encoding/json"
+	"context"
+	"sync"
 
 	"github.com/neptulon/cmap"
 	"github.com/neptulon/shortid"
@@ -10,39 +11,57 @@ import (
 // Sender is a JSON-RPC middleware for sending requests and handling responses asynchronously.
 type Sender struct {
 	send                         func(connID string, msg []byte) error
-	resRoutes                    *cmap.CMap  // message ID (string) -> handler func(ctx *ResCtx) error : expected responses for requests that we've sent
+	resRoutes                    *resRoutes  // message ID (string) -> handler func(ctx *ResCtx) error : expected responses for requests that we've sent
 	m                            *Middleware // Middleware to lazy register our response handler with. See lazyRegisterMiddleware method for details.
-	registeredResponseMiddleware bool
+	registeredResponseMiddleware *sync.Once
 }
 
 // NewSender creates a new Sender middleware.
 func NewSender(m *Middleware, send func(connID string, msg []byte) error) Sender {
 	s := Sender{
-		send:      send,
-		resRoutes: cmap.New(),
-		m:         m,
+		send:                         send,
+		resRoutes:                    &resRoutes{routes: make(map[string]func(ctx *ResCtx) error)},
+		m:                            m,
+		registeredResponseMiddleware: new(sync.Once),
 	}
 
 	return s
 }
 
 // SendRequest sends a JSON-RPC request through the connection denoted by the connection ID with an auto generated request ID.
-// resHandler is called when a response is returned.
+// resHandler is called when a response is returned. No message metadata is sent, see SendRequestContext.
 func (s *Sender) SendRequest(connID string, method string, params interface{}, resHandler func(ctx *ResCtx) error) (reqID string, err error) {
-	s.lazyRegisterMiddleware()
+	return s.SendRequestContext(context.Background(), connID, method, params, resHandler)
+}
 
+// SendRequestContext is the same as SendRequest, except that the message metadata carried by given context (if any) is sent along with the request.
+// See ContextWithMeta for details.
+func (s *Sender) SendRequestContext(ctx context.Context, connID string, method string, params interface{}, resHandler func(ctx *ResCtx) error) (reqID string, err error) {
 	id, err := shortid.UUID()
 	if err != nil {
 		return "", err
 	}
 
-	req := Request{ID: id, Method: method, Params: params}
-	if err = s.sendMsg(connID, req); err != nil {
-		return "", err
+	return id, s.sendRequest(ctx, connID, id, method, params, resHandler)
+}
+
+// sendRequest sends a JSON-RPC request with given ID, for the callers that need to know the ID before the request is sent.
+func (s *Sender) sendRequest(ctx context.Context, connID string, id string, method string, params interface{}, resHandler func(ctx *ResCtx) error) error {
+	s.lazyRegisterMiddleware()
+
+	// register the response handler before sending the request as the response might arrive before sendMsg returns
+	s.resRoutes.set(id, resHandler)
+	if err := s.sendMsg(connID, Request{ID: id, Method: method, Params: params, Meta: MetaFromContext(ctx)}); err != nil {
+		s.resRoutes.take(id)
+		return err
 	}
 
-	s.resRoutes.Set(req.ID, resHandler)
-	return id, nil
+	return nil
+}
+
+// Pending returns the number of requests sent which are still waiting for a response.
+func (s *Sender) Pending() int {
+	return s.resRoutes.len()
 }
 
 // SendRequestArr sends a JSON-RPC request through the connection denoted by the connection ID, with array params and auto generated request ID.
@@ -52,8 +71,15 @@ func (s *Sender) SendRequestArr(connID string, method string, resHandler func(ct
 }
 
 // SendNotification sends a JSON-RPC notification through the connection denoted by the connection ID with structured params object.
+// No message metadata is sent, see SendNotificationContext.
 func (s *Sender) SendNotification(connID string, method string, params interface{}) error {
-	return s.sendMsg(connID, Notification{Method: method, Params: params})
+	return s.SendNotificationContext(context.Background(), connID, method, params)
+}
+
+// SendNotificationContext is the same as SendNotification, except that the message metadata carried by given context (if any) is sent along with the notification.
+// See ContextWithMeta for details.
+func (s *Sender) SendNotificationContext(ctx context.Context, connID string, method string, params interface{}) error {
+	return s.sendMsg(connID, Notification{Method: method, Params: params, Meta: MetaFromContext(ctx)})
 }
 
 // SendNotificationArr sends a JSON-RPC notification message through the connection denoted by the connection ID with array params.
@@ -66,35 +92,98 @@ func (s *Sender) SendResponse(connID string, id string, result interface{}, err
 	return s.sendMsg(connID, Response{ID: id, Result: result, Error: err})
 }
 
-// SendMsg sends any JSON-RPC message through the connection denoted by the connection ID.
+// SendMsg encodes (and compresses, if enabled) any JSON-RPC message, writing RawMessage params and results as is, and sends it through the connection denoted by the connection ID.
 func (s *Sender) sendMsg(connID string, msg interface{}) error {
-	data, err := json.Marshal(msg)
+	codec := s.m.Codec(connID)
+
+	var err error
+	switch m := msg.(type) {
+	case Request:
+		m.Params, err = rawValue(codec, m.Params)
+		msg = m
+	case Notification:
+		m.Params, err = rawValue(codec, m.Params)
+		msg = m
+	case Response:
+		m.Result, err = rawValue(codec, m.Result)
+		msg = m
+	}
+	if err != nil {
+		return err
+	}
+
+	data, err := codec.Marshal(msg)
 	if err != nil {
 		return err
 	}
 
+	if data, err = s.m.compress(connID, data); err != nil {
+		return err
+	}
+
 	return s.send(connID, data)
 }
 
 // Sender middleware should be registered the last so all the middleware will intercept the incoming response messages
 // before they are delivered to the final user handler.
 func (s *Sender) lazyRegisterMiddleware() {
-	if !s.registeredResponseMiddleware {
-		s.m.ResMiddleware(s.resMiddleware)
-	}
-
-	s.registeredResponseMiddleware = true
+	s.registeredResponseMiddleware.Do(func() { s.m.ResMiddleware(s.resMiddleware) })
 }
 
 // ResMiddleware is a JSON-RPC incoming response handler middleware.
 func (s *Sender) resMiddleware(ctx *ResCtx) error {
-	if resHandler, ok := s.resRoutes.GetOk(ctx.id); ok {
-		err := resHandler.(func(ctx *ResCtx) error)(ctx)
-		s.resRoutes.Delete(ctx.id)
-		if err != nil {
-			return err
-		}
+	if resHandler, ok := s.resRoutes.take(ctx.id); ok {
+		ctx.handled = true
+		return resHandler(ctx)
 	}
 
-	return nil
+	return ctx.Next()
+}
+
+// failPending answers all the requests waiting for a response with ConnLostCode error, i.e. when the connection is known to be lost.
+func (s *Sender) failPending(client *Client) {
+	for id, resHandler := range s.resRoutes.takeAll() {
+		err := &resError{Code: ConnLostCode, Message: "Connection lost before the response was received"}
+		resHandler(newResCtx(id, nil, err, client, nil, cmap.New(), JSON))
+	}
+}
+
+// resRoutes is a thread-safe registry of response handlers.
+type resRoutes struct {
+	mutex  sync.Mutex
+	routes map[string]func(ctx *ResCtx) error
+}
+
+func (r *resRoutes) set(id string, resHandler func(ctx *ResCtx) error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.routes[id] = resHandler
+}
+
+// take removes and returns the response handler for given message ID, so only one caller can ever get it.
+func (r *resRoutes) take(id string) (func(ctx *ResCtx) error, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	h, ok := r.routes[id]
+	delete(r.routes, id)
+	return h, ok
+}
+
+// takeAll removes and returns all the response handlers.
+func (r *resRoutes) takeAll() map[string]func(ctx *ResCtx) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	routes := r.routes
+	r.routes = make(map[string]func(ctx *ResCtx) error)
+	return routes
+}
+
+func (r *resRoutes) len() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return len(r.routes)
 }
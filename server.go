@@ -11,10 +11,15 @@ import (
 type Server struct {
 	Middleware
 	Sender
-	neptulon *neptulon.Server
+	NotifyShutdown bool // Send ShutdownMethod notification to the connections on Shutdown.
+
+	neptulon       *neptulon.Server
+	disconnHandler func(client *neptulon.Client)
 }
 
 // NewServer creates a Neptulon JSON-RPC server.
+// It takes over the disconnect handler of the Neptulon server to release the peer of each closed connection,
+// so callers must use Server.DisconnHandler instead of registering one on the Neptulon server directly, which would replace it.
 func NewServer(n *neptulon.Server) (*Server, error) {
 	if n == nil {
 		return nil, errors.New("given Neptulon server instance is nil")
@@ -22,7 +27,21 @@ func NewServer(n *neptulon.Server) (*Server, error) {
 
 	s := Server{neptulon: n}
 	n.MiddlewareIn(s.Middleware.neptulonMiddleware)
+	n.DisconnHandler(s.disconnected)
 	s.Sender = NewSender(&s.Middleware, n.Send)
 
 	return &s, nil
 }
+
+// DisconnHandler registers a function to handle client disconnection events.
+// Should be set before the server starts running.
+func (s *Server) DisconnHandler(handler func(client *neptulon.Client)) {
+	s.disconnHandler = handler
+}
+
+func (s *Server) disconnected(client *neptulon.Client) {
+	s.forgetConn(client.ConnID())
+	if s.disconnHandler != nil {
+		s.disconnHandler(client)
+	}
+}
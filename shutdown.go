@@ -0,0 +1,163 @@
+package jsonrpc
+
+import (
+	"context"
+	"sync"
+)
+
+// ShutdownMethod is the method name of the notification sent to all connections when a server starts shutting down, if enabled.
+const ShutdownMethod = "rpc.shutdown"
+
+// ShutdownCode is the error code of the responses to the requests received while a server is shutting down.
+const ShutdownCode = -32001
+
+// drainer keeps track of the request handlers running, and rejects new requests once draining starts.
+type drainer struct {
+	mutex    sync.Mutex
+	draining bool
+	running  sync.WaitGroup
+}
+
+// start registers a new request handler, unless draining has started.
+func (d *drainer) start() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.draining {
+		return false
+	}
+
+	d.running.Add(1)
+	return true
+}
+
+func (d *drainer) done() {
+	d.running.Done()
+}
+
+// hold registers a request handler continuing asynchronously, even if draining has started.
+// Should only be called by a running request handler, which guarantees that the wait has not finished yet.
+func (d *drainer) hold() (release func()) {
+	d.running.Add(1)
+	var once sync.Once
+	return func() { once.Do(d.running.Done) }
+}
+
+// drain stops accepting new requests.
+func (d *drainer) drain() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.draining = true
+}
+
+// wait blocks until all the running request handlers return, or the context is done.
+func (d *drainer) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		d.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shutdown drains the middleware stack: new requests are answered with ShutdownCode error right away,
+// ShutdownMethod notification is sent to given connections (if any), and running request handlers are waited for until the context is done.
+func (mw *Middleware) shutdown(ctx context.Context, s *Sender, notify []string) error {
+	mw.drainer.drain()
+	for _, connID := range notify {
+		s.SendNotification(connID, ShutdownMethod, nil)
+	}
+
+	return mw.drainer.wait(ctx)
+}
+
+// peerIDs returns the IDs of all the connections which have sent messages to this middleware stack and are not closed yet.
+// Peers are forgotten as their connections close, so closed connections are not notified.
+func (mw *Middleware) peerIDs() []string {
+	mw.mutex.RLock()
+	defer mw.mutex.RUnlock()
+
+	ids := make([]string, 0, len(mw.peers))
+	for id := range mw.peers {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// Shutdown gracefully shuts down the server: new requests are answered with ShutdownCode error, running request handlers are waited for to respond,
+// and then all connections are closed. If the context is done before the handlers return, connections are closed right away and the context error is returned.
+// Handlers responding asynchronously are waited for only if they hold the shutdown, see ReqCtx.Hold.
+// Only the connections which have sent any messages to the server can be notified with NotifyShutdown.
+func (s *Server) Shutdown(ctx context.Context) error {
+	var notify []string
+	if s.NotifyShutdown {
+		notify = s.Middleware.peerIDs()
+	}
+
+	err := s.Middleware.shutdown(ctx, &s.Sender, notify)
+	if cerr := s.neptulon.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// Shutdown gracefully shuts down the server. See Server.Shutdown for details.
+func (s *WebSocketServer) Shutdown(ctx context.Context) error {
+	err := s.Middleware.shutdown(ctx, &s.Sender, s.notifyIDs())
+	if cerr := s.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+func (s *WebSocketServer) notifyIDs() []string {
+	if !s.NotifyShutdown {
+		return nil
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	ids := make([]string, 0, len(s.conns))
+	for id := range s.conns {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// Shutdown gracefully shuts down the server. See Server.Shutdown for details.
+func (s *PipeServer) Shutdown(ctx context.Context) error {
+	err := s.Middleware.shutdown(ctx, &s.Sender, s.notifyIDs())
+	if cerr := s.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+func (s *PipeServer) notifyIDs() []string {
+	if !s.NotifyShutdown {
+		return nil
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	ids := make([]string, 0, len(s.conns))
+	for id := range s.conns {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
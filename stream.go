@@ -0,0 +1,172 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/neptulon/cmap"
+	"github.com/neptulon/shortid"
+)
+
+// DefaultMaxMessageSize is the default maximum size of an incoming message in bytes.
+const DefaultMaxMessageSize = 32 * 1024 * 1024
+
+// StreamPeer is a bidirectional JSON-RPC peer exchanging messages over a byte stream (i.e. stdin/stdout, subprocess pipes).
+// It is a Client over the stream connection, so the whole Client API is available on it.
+// Messages are framed with Content-Length headers as in Language Server Protocol:
+//
+//	Content-Length: 52\r\n
+//	\r\n
+//	{"id":"1","method":"ping","params":{"message":"hi"}}
+type StreamPeer struct {
+	*Client
+	MaxMessageSize int // Maximum size of incoming messages in bytes, larger ones fail the stream. Default is DefaultMaxMessageSize. Should be set before Serve.
+
+	conn *streamConn
+}
+
+// NewStreamPeer creates a JSON-RPC peer over the given stream. Call Serve to start receiving messages.
+func NewStreamPeer(rwc io.ReadWriteCloser) (*StreamPeer, error) {
+	conn, err := newStreamConn(rwc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StreamPeer{Client: UseConn(conn), conn: conn}, nil
+}
+
+// NewStdioPeer creates a JSON-RPC peer over standard input and output of the current process.
+func NewStdioPeer() (*StreamPeer, error) {
+	return NewStreamPeer(stdio{})
+}
+
+// Serve starts receiving messages and blocks until the stream is closed or a framing error occurs.
+// Returns nil if the stream was closed by the peer (EOF).
+func (p *StreamPeer) Serve() error {
+	p.conn.maxSize = p.MaxMessageSize
+	return p.Client.Serve(p.conn)
+}
+
+// streamConn is a connection over a byte stream carrying Content-Length framed messages.
+type streamConn struct {
+	id      string
+	rwc     io.ReadWriteCloser
+	reader  *bufio.Reader
+	session *cmap.CMap
+	writeMu sync.Mutex
+	maxSize int // see StreamPeer.MaxMessageSize
+}
+
+func newStreamConn(rwc io.ReadWriteCloser) (*streamConn, error) {
+	id, err := shortid.UUID()
+	if err != nil {
+		return nil, err
+	}
+
+	return &streamConn{
+		id:      id,
+		rwc:     rwc,
+		reader:  bufio.NewReader(rwc),
+		session: cmap.New(),
+	}, nil
+}
+
+// ConnID is a randomly generated unique connection ID.
+func (c *streamConn) ConnID() string {
+	return c.id
+}
+
+// Session is a thread-safe data store for storing arbitrary data for this connection session.
+func (c *streamConn) Session() *cmap.CMap {
+	return c.session
+}
+
+// Send writes the given message to the stream prefixed with a Content-Length header.
+func (c *streamConn) Send(msg []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if _, err := fmt.Fprintf(c.rwc, "Content-Length: %d\r\n\r\n", len(msg)); err != nil {
+		return err
+	}
+
+	_, err := c.rwc.Write(msg)
+	return err
+}
+
+// Close closes the underlying stream.
+func (c *streamConn) Close() error {
+	return c.rwc.Close()
+}
+
+// Receive reads the next Content-Length framed message from the stream.
+func (c *streamConn) Receive() ([]byte, error) {
+	length := -1
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && length == -1 && line == "" {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("cannot read message header: %v", err)
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+
+		colon := strings.IndexByte(line, ':')
+		if colon == -1 {
+			return nil, fmt.Errorf("invalid message header: %q", line)
+		}
+		if strings.EqualFold(strings.TrimSpace(line[:colon]), "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(line[colon+1:])); err != nil || length < 0 {
+				return nil, fmt.Errorf("invalid Content-Length header: %q", line)
+			}
+		}
+	}
+
+	if length == -1 {
+		return nil, errors.New("missing Content-Length header")
+	}
+	maxSize := c.maxSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+	if length > maxSize {
+		return nil, fmt.Errorf("message of %v bytes exceeds the maximum message size of %v bytes", length, maxSize)
+	}
+
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, msg); err != nil {
+		return nil, fmt.Errorf("cannot read message body: %v", err)
+	}
+
+	return msg, nil
+}
+
+// stdio is an io.ReadWriteCloser over standard input and output of the current process.
+type stdio struct{}
+
+func (stdio) Read(p []byte) (int, error) {
+	return os.Stdin.Read(p)
+}
+
+func (stdio) Write(p []byte) (int, error) {
+	return os.Stdout.Write(p)
+}
+
+func (stdio) Close() error {
+	if err := os.Stdin.Close(); err != nil {
+		return err
+	}
+
+	return os.Stdout.Close()
+}
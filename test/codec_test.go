@@ -0,0 +1,50 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/neptulon/jsonrpc"
+	"github.com/neptulon/jsonrpc/middleware"
+)
+
+func TestCodecs(t *testing.T) {
+	for _, codec := range []jsonrpc.Codec{jsonrpc.JSON, jsonrpc.MsgPack, jsonrpc.CBOR} {
+		s := jsonrpc.NewPipeServer()
+		s.UseCodec(codec)
+		rout, err := jsonrpc.NewRouter(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rout.Request("echo", middleware.Echo)
+
+		c, err := s.Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.UseCodec(codec)
+
+		res := make(chan string)
+		if _, err := c.SendRequest("echo", echoMsg{Message: "Hello " + codec.Name()}, func(ctx *jsonrpc.ResCtx) error {
+			var msg echoMsg
+			if err := ctx.Result(&msg); err != nil {
+				t.Fatal(err)
+			}
+			res <- msg.Message
+			return ctx.Next()
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case msg := <-res:
+			if msg != "Hello "+codec.Name() {
+				t.Fatalf("expected: %v got: %v", "Hello "+codec.Name(), msg)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("timed out waiting for %v response", codec.Name())
+		}
+
+		s.Close()
+	}
+}
@@ -0,0 +1,90 @@
+package test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/neptulon/jsonrpc"
+	"github.com/neptulon/jsonrpc/middleware"
+)
+
+// countingRWC counts the bytes written to the underlying stream.
+type countingRWC struct {
+	io.ReadWriteCloser
+	written int64
+}
+
+func (c *countingRWC) Write(p []byte) (int, error) {
+	atomic.AddInt64(&c.written, int64(len(p)))
+	return c.ReadWriteCloser.Write(p)
+}
+
+func TestCompression(t *testing.T) {
+	r1, w1 := io.Pipe()
+	r2, w2 := io.Pipe()
+	serverStream := &countingRWC{ReadWriteCloser: pipeRWC{r1, w2}}
+
+	server, err := jsonrpc.NewStreamPeer(serverStream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := jsonrpc.NewStreamPeer(pipeRWC{r2, w1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []*jsonrpc.StreamPeer{server, client} {
+		p.UseExtensions(jsonrpc.ExtCompression)
+		p.UseCompression(1024)
+	}
+	server.HandleRequest("echo", middleware.Echo)
+	go server.Serve()
+	go client.Serve()
+	defer client.Close()
+	defer server.Close()
+
+	large := strings.Repeat("Hello! ", 100000)
+	res := make(chan string)
+	if _, err := client.SendRequest("echo", echoMsg{Message: large}, func(ctx *jsonrpc.ResCtx) error {
+		var msg echoMsg
+		if err := ctx.Result(&msg); err != nil {
+			t.Fatal(err)
+		}
+		res <- msg.Message
+		return ctx.Next()
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-res:
+		if msg != large {
+			t.Fatal("echoed message does not match the original")
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for response")
+	}
+
+	if written := atomic.LoadInt64(&serverStream.written); written > int64(len(large)/10) {
+		t.Fatalf("expected compressed response, server wrote %v bytes for a %v bytes message", written, len(large))
+	}
+}
+
+func TestDecompressionLimit(t *testing.T) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(`{"method":"hello","params":"` + strings.Repeat("a", 1024) + `"}`))
+	w.Close()
+
+	var mw jsonrpc.Middleware
+	mw.UseExtensions(jsonrpc.ExtCompression)
+	mw.UseDecompressionLimit(512)
+	if err := mw.HandleMsg(newDeadConn(), buf.Bytes()); err == nil || !strings.Contains(err.Error(), "maximum message size") {
+		t.Fatalf("expected maximum message size error, got: %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/neptulon/jsonrpc"
+	"github.com/neptulon/jsonrpc/middleware"
+)
+
+type user struct {
+	ID    string   `json:"id"`
+	Name  string   `json:"name"`
+	Email string   `json:"email,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+type getUserParams struct {
+	ID string `json:"id"`
+}
+
+func TestDiscover(t *testing.T) {
+	s := jsonrpc.NewPipeServer()
+	defer s.Close()
+	rout, err := jsonrpc.NewRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rout.Request("echo", middleware.Echo)
+	if err := rout.TypedRequest("users.get", func(ctx *jsonrpc.ReqCtx, params getUserParams) (*user, error) {
+		if params.ID != "1" {
+			return nil, &jsonrpc.ResError{Code: 404, Message: "User not found"}
+		}
+		return &user{ID: "1", Name: "Jane"}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rout.TypedNotification("users.seen", func(ctx *jsonrpc.NotCtx, params getUserParams) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := rout.TypedRequest("invalid", func(ctx *jsonrpc.ReqCtx) error { return nil }); err == nil {
+		t.Fatal("expected invalid handler signature to be rejected")
+	}
+
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	docs := make(chan jsonrpc.OpenRPC)
+	if _, err := c.SendRequest("rpc.discover", nil, func(ctx *jsonrpc.ResCtx) error {
+		var doc jsonrpc.OpenRPC
+		if err := ctx.Result(&doc); err != nil {
+			t.Fatal(err)
+		}
+		docs <- doc
+		return ctx.Next()
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc jsonrpc.OpenRPC
+	select {
+	case doc = <-docs:
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for response")
+	}
+
+	if len(doc.Methods) != 3 || doc.Methods[0].Name != "echo" || doc.Methods[1].Name != "users.get" || doc.Methods[2].Name != "users.seen" {
+		t.Fatalf("unexpected methods in discovery document: %+v", doc.Methods)
+	}
+	get := doc.Methods[1]
+	if get.ParamStructure != "by-name" || len(get.Params) != 1 || get.Params[0].Name != "id" || !get.Params[0].Required {
+		t.Fatalf("unexpected params for users.get: %+v", get.Params)
+	}
+	res := get.Result.Schema
+	if res.Type != "object" || res.Properties["tags"].Type != "array" || len(res.Required) != 2 {
+		t.Fatalf("unexpected result schema for users.get: %+v", res)
+	}
+	if doc.Methods[2].Result != nil {
+		t.Fatal("expected notification to be described without a result")
+	}
+
+	errs := make(chan *jsonrpc.ResError)
+	if _, err := c.SendRequest("users.get", getUserParams{ID: "2"}, func(ctx *jsonrpc.ResCtx) error {
+		errs <- ctx.Err
+		return ctx.Next()
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-errs:
+		if err == nil || err.Code != 404 {
+			t.Fatalf("expected 404 error, got: %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for response")
+	}
+}
+
+func TestTypedRequestNil(t *testing.T) {
+	s := jsonrpc.NewPipeServer()
+	defer s.Close()
+	rout, err := jsonrpc.NewRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rout.TypedRequest("users.find", func(ctx *jsonrpc.ReqCtx, params getUserParams) (*user, error) {
+		var notFound *jsonrpc.ResError
+		return nil, notFound
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rout.TypedRequest("nil", nil); err == nil {
+		t.Fatal("expected nil handler to be rejected")
+	}
+	var handler func(ctx *jsonrpc.ReqCtx, params getUserParams) (*user, error)
+	if err := rout.TypedRequest("nil", handler); err == nil {
+		t.Fatal("expected nil handler func to be rejected")
+	}
+
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	res := &user{}
+	if err := c.Call(ctx, "users.find", getUserParams{ID: "1"}, &res); err != nil {
+		t.Fatalf("expected null result for nil result and typed nil error, got: %v", err)
+	}
+	if res != nil {
+		t.Fatalf("expected null result, got: %+v", res)
+	}
+}
@@ -0,0 +1,198 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/neptulon/jsonrpc"
+	"github.com/neptulon/jsonrpc/middleware"
+)
+
+func TestGateway(t *testing.T) {
+	backend := jsonrpc.NewPipeServer()
+	defer backend.Close()
+	brout, err := jsonrpc.NewRouter(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	brout.Request("billing.echo", middleware.Echo)
+	brout.Request("billing.hang", func(ctx *jsonrpc.ReqCtx) error { return nil })
+	brout.Request("billing.notify", func(ctx *jsonrpc.ReqCtx) error {
+		if err := ctx.Client.SendNotification("billing.event", "paid"); err != nil {
+			return err
+		}
+		ctx.Res = "ok"
+		return ctx.Next()
+	})
+
+	bc, err := backend.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+	deadLetters := make(chan string, 1)
+	bc.UseDeadLetter(func(connID string, msg []byte) {
+		select {
+		case deadLetters <- string(msg):
+		default:
+		}
+	})
+
+	s := jsonrpc.NewPipeServer()
+	defer s.Close()
+	gw, err := jsonrpc.NewGateway(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw.Timeout = time.Millisecond * 100
+	gw.Route("billing.*", bc)
+	rout, err := jsonrpc.NewRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rout.Request("local.echo", middleware.Echo)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		c, err := s.Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		for j := 0; j < 10; j++ {
+			wg.Add(1)
+			go func(c *jsonrpc.Client, msg string) {
+				defer wg.Done()
+				var res echoMsg
+				if err := c.Call(ctx, "billing.echo", echoMsg{Message: msg}, &res); err != nil {
+					t.Error(err)
+				} else if res.Message != msg {
+					t.Errorf("expected: %v got: %v", msg, res.Message)
+				}
+			}(c, fmt.Sprintf("conn %v msg %v", i, j))
+		}
+	}
+	wg.Wait()
+
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var res echoMsg
+	if err := c.Call(ctx, "local.echo", echoMsg{Message: "local"}, &res); err != nil || res.Message != "local" {
+		t.Fatalf("expected local route to be served, got: %v, %v", res, err)
+	}
+
+	events := make(chan string, 1)
+	c.HandleNotification("billing.event", func(ctx *jsonrpc.NotCtx) error {
+		var e string
+		if err := ctx.Params(&e); err != nil {
+			t.Fatal(err)
+		}
+		events <- e
+		return ctx.Next()
+	})
+	if err := c.Call(ctx, "billing.notify", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-events:
+		if e != "paid" {
+			t.Fatalf("expected relayed notification, got: %v", e)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for relayed notification")
+	}
+	select {
+	case msg := <-deadLetters:
+		t.Fatalf("expected relayed notification to be handled, got dead letter: %v", msg)
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	if err := c.Call(ctx, "billing.hang", nil, nil); err == nil || err.(*jsonrpc.ResError).Code != -32603 {
+		t.Fatalf("expected backend timeout error, got: %v", err)
+	}
+}
+
+func TestGatewayRawForwarding(t *testing.T) {
+	backend := jsonrpc.NewPipeServer()
+	defer backend.Close()
+	brout, err := jsonrpc.NewRouter(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{}, 1)
+	brout.Request("billing.raw", func(ctx *jsonrpc.ReqCtx) error {
+		started <- struct{}{}
+		time.Sleep(time.Millisecond * 100)
+		var params jsonrpc.RawMessage
+		if err := ctx.Params(&params); err != nil {
+			return err
+		}
+		ctx.Res = params
+		return ctx.Next()
+	})
+
+	bc, err := backend.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+
+	s := jsonrpc.NewPipeServer()
+	defer s.Close()
+	gw, err := jsonrpc.NewGateway(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw.Route("billing.", bc)
+
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// integers beyond float64 precision would not survive decoding into interface{}
+	params := `{"amount":9007199254740993}`
+	type result struct {
+		res json.RawMessage
+		err error
+	}
+	results := make(chan result, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		var res json.RawMessage
+		err := c.Call(ctx, "billing.raw", json.RawMessage(params), &res)
+		results <- result{res, err}
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for forwarded request")
+	}
+
+	// shutdown waits for the response of the forwarded request before closing the connections
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	r := <-results
+	if r.err != nil || string(r.res) != params {
+		t.Fatalf("expected: %v got: %s, %v", params, r.res, r.err)
+	}
+}
@@ -0,0 +1,166 @@
+package test
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/neptulon/cmap"
+	"github.com/neptulon/jsonrpc"
+)
+
+func TestHeartbeat(t *testing.T) {
+	s := jsonrpc.NewPipeServer()
+	s.UseHeartbeat(time.Millisecond*10, 2)
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.UseHeartbeat(time.Millisecond*10, 2)
+	defer c.Close()
+
+	select {
+	case <-c.Done():
+		t.Fatal("expected healthy connection to stay open")
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestHeartbeatDeadPeer(t *testing.T) {
+	conn := newDeadConn()
+	c := jsonrpc.UseConn(conn)
+	c.UseHeartbeat(time.Millisecond*10, 2)
+	go c.Serve(conn)
+
+	select {
+	case <-c.Done():
+	case <-time.After(time.Second * 5):
+		t.Fatal("expected dead connection to be closed")
+	}
+}
+
+func TestHeartbeatForgetsDeadPeer(t *testing.T) {
+	// push based transports (i.e. Neptulon) deliver messages through HandleMsg and might not report closed connections back
+	mw := new(jsonrpc.Middleware)
+	mw.UseHeartbeat(time.Millisecond*10, 2)
+	peers := make(chan *jsonrpc.Peer, 1)
+	mw.ReqMiddleware(func(ctx *jsonrpc.ReqCtx) error {
+		peers <- ctx.Peer
+		return ctx.Next()
+	})
+
+	if err := mw.HandleMsg(newDeadConn(), []byte(`{"jsonrpc":"2.0","id":"1","method":"hello"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-(<-peers).Context().Done():
+	case <-time.After(time.Second * 5):
+		t.Fatal("expected peer of the dead connection to be forgotten")
+	}
+}
+
+func TestHeartbeatDefaultMisses(t *testing.T) {
+	mw := new(jsonrpc.Middleware)
+	mw.UseHeartbeat(time.Second, 0)
+	conn := &deadlineConn{deadConn: newDeadConn(), deadlines: make(chan int, 1)}
+	defer conn.Close()
+
+	if err := mw.HandleMsg(conn, []byte(`{"jsonrpc":"2.0","method":"hello"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// deadline covers the interval for each of the allowed missed pings and one more
+	if seconds := <-conn.deadlines; seconds != jsonrpc.DefaultHeartbeatMisses+1 {
+		t.Fatalf("expected deadline of %v seconds, got: %v", jsonrpc.DefaultHeartbeatMisses+1, seconds)
+	}
+}
+
+// deadlineConn is a deadConn with read/write deadlines, like Neptulon connections.
+type deadlineConn struct {
+	*deadConn
+	deadlines chan int
+}
+
+func (c *deadlineConn) SetDeadline(seconds int) {
+	select {
+	case c.deadlines <- seconds:
+	default:
+	}
+}
+
+// deadConn is a connection to an unresponsive peer which discards all sent messages and never receives any.
+type deadConn struct {
+	session *cmap.CMap
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func newDeadConn() *deadConn {
+	return &deadConn{session: cmap.New(), closed: make(chan struct{})}
+}
+
+func (c *deadConn) ConnID() string        { return "dead" }
+func (c *deadConn) Session() *cmap.CMap   { return c.session }
+func (c *deadConn) Send(msg []byte) error { return nil }
+
+func (c *deadConn) Receive() ([]byte, error) {
+	<-c.closed
+	return nil, io.EOF
+}
+
+func (c *deadConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+func TestHeartbeatPingNotRouted(t *testing.T) {
+	s := jsonrpc.NewWebSocketServer(0)
+	s.UseHeartbeat(time.Millisecond*10, 1)
+	hs := httptest.NewServer(s)
+	defer hs.Close()
+	defer s.Close()
+
+	// a plain WebSocket client which answers all requests with method not found error, including pings
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(hs.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","method":"hello"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	pings := 0
+	ws.SetReadDeadline(time.Now().Add(time.Millisecond * 200))
+	for {
+		_, msg, err := ws.ReadMessage()
+		if err != nil {
+			if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+				t.Fatalf("expected connection to stay open, got: %v", err)
+			}
+			break
+		}
+		var req struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(msg, &req); err != nil {
+			t.Fatal(err)
+		}
+		pings++
+		res := `{"jsonrpc":"2.0","id":"` + req.ID + `","error":{"code":-32601,"message":"Method not found"}}`
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(res)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if pings <= 1 {
+		t.Fatalf("expected the connection to be pinged more than the allowed misses, pinged %v times", pings)
+	}
+}
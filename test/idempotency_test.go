@@ -0,0 +1,186 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/neptulon/jsonrpc"
+	"github.com/neptulon/jsonrpc/middleware"
+)
+
+func TestIdempotency(t *testing.T) {
+	s := jsonrpc.NewPipeServer()
+	defer s.Close()
+	if _, err := middleware.NewIdempotency(s); err != nil {
+		t.Fatal(err)
+	}
+	rout, err := jsonrpc.NewRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mutex sync.Mutex
+	charges := 0
+	started, release := make(chan struct{}, 10), make(chan struct{})
+	rout.Request("charge", func(ctx *jsonrpc.ReqCtx) error {
+		started <- struct{}{}
+		<-release
+		mutex.Lock()
+		charges++
+		ctx.Res = charges
+		mutex.Unlock()
+		return ctx.Next()
+	})
+	failures := 0
+	rout.Request("flaky", func(ctx *jsonrpc.ReqCtx) error {
+		mutex.Lock()
+		failures++
+		mutex.Unlock()
+		ctx.Err = &jsonrpc.ResError{Code: -32603, Message: "Internal error", Data: "database is down"}
+		return ctx.Next()
+	})
+
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	keyCtx := jsonrpc.ContextWithMeta(ctx, map[string]string{middleware.IdempotencyKey: "k1"})
+
+	// concurrent duplicates wait for the first one and get the same response
+	results := make(chan int, 2)
+	for n := 0; n < 2; n++ {
+		go func() {
+			var res int
+			if err := c.Call(keyCtx, "charge", nil, &res); err != nil {
+				t.Error(err)
+			}
+			results <- res
+		}()
+	}
+	<-started
+	time.Sleep(time.Millisecond * 20) // let the duplicate arrive
+	close(release)
+	if a, b := <-results, <-results; a != 1 || b != 1 {
+		t.Fatalf("expected both calls to get the first charge, got: %v, %v", a, b)
+	}
+
+	// retry after the fact is replayed from the cache, also with the key in params
+	var res int
+	if err := c.Call(keyCtx, "charge", nil, &res); err != nil || res != 1 {
+		t.Fatalf("expected cached charge, got: %v, %v", res, err)
+	}
+	params := map[string]string{"idempotencyKey": "k2"}
+	for n := 0; n < 2; n++ {
+		if err := c.Call(ctx, "charge", params, &res); err != nil || res != 2 {
+			t.Fatalf("expected second charge, got: %v, %v", res, err)
+		}
+	}
+	if err := c.Call(ctx, "charge", nil, &res); err != nil || res != 3 {
+		t.Fatalf("expected requests without a key to run, got: %v, %v", res, err)
+	}
+	err = c.Call(ctx, "charge", map[string]interface{}{"idempotencyKey": "k2", "amount": 5}, &res)
+	if resErr, ok := err.(*jsonrpc.ResError); !ok || resErr.Code != -32602 {
+		t.Fatalf("expected key reused with different params to be rejected, got: %v", err)
+	}
+
+	// params are compared by value rather than encoding
+	if err := c.Call(ctx, "charge", json.RawMessage(`{"amount":5,"idempotencyKey":"k3"}`), &res); err != nil || res != 4 {
+		t.Fatalf("expected fourth charge, got: %v, %v", res, err)
+	}
+	if err := c.Call(ctx, "charge", json.RawMessage(`{"idempotencyKey":"k3","amount":5.0}`), &res); err != nil || res != 4 {
+		t.Fatalf("expected cached charge for params in different key order, got: %v, %v", res, err)
+	}
+
+	// internal errors are not cached so the request can be retried
+	for n := 0; n < 2; n++ {
+		if err := c.Call(keyCtx, "flaky", nil, nil); err == nil || err.(*jsonrpc.ResError).Code != -32603 {
+			t.Fatalf("expected internal error, got: %v", err)
+		}
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if failures != 2 {
+		t.Fatalf("expected failed request to run again, ran %v times", failures)
+	}
+}
+
+func TestIdempotencyScope(t *testing.T) {
+	s := jsonrpc.NewPipeServer()
+	defer s.Close()
+	// authentication middleware scopes the keys to the user
+	s.ReqMiddleware(func(ctx *jsonrpc.ReqCtx) error {
+		ctx.Client.Session().Set(middleware.IdempotencyScopeKey, ctx.Meta()["user"])
+		return ctx.Next()
+	})
+	idem, err := middleware.NewIdempotency(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idem.Wait = time.Millisecond * 50
+	rout, err := jsonrpc.NewRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mutex sync.Mutex
+	charges := 0
+	rout.Request("charge", func(ctx *jsonrpc.ReqCtx) error {
+		mutex.Lock()
+		charges++
+		ctx.Res = charges
+		mutex.Unlock()
+		return ctx.Next()
+	})
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	defer close(release)
+	rout.Request("hang", func(ctx *jsonrpc.ReqCtx) error {
+		started <- struct{}{}
+		<-release
+		ctx.Res = "done"
+		return ctx.Next()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	call := func(user, method string) (int, error) {
+		c, err := s.Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		var res int
+		err = c.Call(jsonrpc.ContextWithMeta(ctx, map[string]string{"user": user, middleware.IdempotencyKey: "k1"}), method, nil, &res)
+		return res, err
+	}
+
+	// same key is replayed to the same user over a new connection, but not to the other users
+	for _, e := range []struct {
+		user string
+		res  int
+	}{{"alice", 1}, {"alice", 1}, {"bob", 2}} {
+		if res, err := call(e.user, "charge"); err != nil || res != e.res {
+			t.Fatalf("expected charge %v for %v, got: %v, %v", e.res, e.user, res, err)
+		}
+	}
+
+	// duplicates give up waiting for a request that does not return
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.SendRequestContext(jsonrpc.ContextWithMeta(ctx, map[string]string{"user": "alice", middleware.IdempotencyKey: "k1"}), "hang", nil, func(ctx *jsonrpc.ResCtx) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if _, err := call("alice", "hang"); err == nil || err.(*jsonrpc.ResError).Code != -32603 {
+		t.Fatalf("expected waiting duplicate to time out, got: %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/neptulon/jsonrpc"
+)
+
+func TestNotificationMeta(t *testing.T) {
+	s := jsonrpc.NewPipeServer()
+	defer s.Close()
+	metas := make(chan map[string]string, 1)
+	s.NotMiddleware(func(ctx *jsonrpc.NotCtx) error {
+		metas <- ctx.Meta()
+		return ctx.Next()
+	})
+
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx := jsonrpc.ContextWithMeta(context.Background(), map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"})
+	if err := c.SendNotificationContext(ctx, "event", nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case meta := <-metas:
+		if meta["traceparent"] != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+			t.Fatalf("expected notification metadata to be sent, got: %v", meta)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for notification")
+	}
+
+	// metadata is not a part of JSON-RPC 2.0, so a malformed one does not fail the message
+	if err := s.HandleMsg(newDeadConn(), []byte(`{"jsonrpc":"2.0","method":"event","meta":"oops"}`)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case meta := <-metas:
+		if meta != nil {
+			t.Fatalf("expected malformed metadata to be ignored, got: %v", meta)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for notification with malformed metadata")
+	}
+}
@@ -0,0 +1,154 @@
+package test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neptulon/jsonrpc"
+	"github.com/neptulon/jsonrpc/middleware"
+)
+
+func TestMetrics(t *testing.T) {
+	s := jsonrpc.NewPipeServer()
+	defer s.Close()
+	metrics, err := middleware.NewMetrics(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rout, err := jsonrpc.NewRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rout.Request("echo", middleware.Echo)
+	rout.Request("fail", func(ctx *jsonrpc.ReqCtx) error {
+		ctx.Err = &jsonrpc.ResError{Code: 418, Message: "I'm a teapot"}
+		return ctx.Next()
+	})
+	rout.Request("hang", func(ctx *jsonrpc.ReqCtx) error { return nil })
+
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	metrics.TrackPending(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := c.Call(ctx, "echo", "hi", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Call(ctx, "fail", nil, nil); err == nil {
+		t.Fatal("expected error response")
+	}
+	if err := c.Call(ctx, "missing", nil, nil); err == nil {
+		t.Fatal("expected method not found error")
+	}
+	if err := c.SendNotification("nowhere", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.SendRequest("hang", nil, func(ctx *jsonrpc.ResCtx) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		`jsonrpc_requests_total{method="echo"} 1`,
+		`jsonrpc_request_errors_total{method="fail",code="418"} 1`,
+		`jsonrpc_request_duration_seconds_bucket{method="echo",le="+Inf"} 1`,
+		`jsonrpc_request_duration_seconds_count{method="fail"} 1`,
+		`jsonrpc_requests_in_flight{method="echo"} 0`,
+		`jsonrpc_requests_total{method="unknown"} 1`,
+		`jsonrpc_request_errors_total{method="unknown",code="-32601"} 1`,
+		`jsonrpc_notifications_total{method="unknown"} 1`,
+		`jsonrpc_unhandled_total{type="request"} 1`,
+		`jsonrpc_unhandled_total{type="notification"} 1`,
+		`jsonrpc_pending_requests 1`,
+		"# TYPE jsonrpc_request_duration_seconds histogram",
+	}
+
+	var body string
+	for {
+		rec := httptest.NewRecorder()
+		metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		body = rec.Body.String()
+
+		missing := ""
+		for _, e := range expected {
+			if !strings.Contains(body, e+"\n") {
+				missing = e
+				break
+			}
+		}
+		if missing == "" {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("expected metric %v in:\n%v", missing, body)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// methods sent by the client that no route handles must not show up as labels
+	if strings.Contains(body, "missing") || strings.Contains(body, "nowhere") {
+		t.Fatalf("expected unknown methods not to be labeled by name:\n%v", body)
+	}
+}
+
+func TestMetricsCountUnhandled(t *testing.T) {
+	s := jsonrpc.NewPipeServer()
+	defer s.Close()
+	rout, err := jsonrpc.NewRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics, err := middleware.NewMetrics(metricsGroup{rout.Group("svc.")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics.CountUnhandled(s)
+
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := c.Call(ctx, "svc.missing", nil, nil); err == nil {
+		t.Fatal("expected method not found error")
+	}
+
+	// requests are recorded after the response is written
+	expected := []string{`jsonrpc_requests_total{method="unknown"} 1`, `jsonrpc_unhandled_total{type="request"} 1`}
+	for {
+		rec := httptest.NewRecorder()
+		metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		body := rec.Body.String()
+
+		missing := ""
+		for _, e := range expected {
+			if !strings.Contains(body, e+"\n") {
+				missing = e
+				break
+			}
+		}
+		if missing == "" {
+			return
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("expected metric %v in:\n%v", missing, body)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+// metricsGroup adapts a router group to the metrics middleware, which also registers response middleware.
+type metricsGroup struct {
+	*jsonrpc.Router
+}
+
+func (metricsGroup) ResMiddleware(resMiddleware ...func(ctx *jsonrpc.ResCtx) error) {}
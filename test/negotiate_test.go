@@ -0,0 +1,148 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/neptulon/jsonrpc"
+	"github.com/neptulon/jsonrpc/middleware"
+)
+
+func TestNegotiate(t *testing.T) {
+	s := jsonrpc.NewPipeServer()
+	defer s.Close()
+	s.AcceptNegotiation([]jsonrpc.Codec{jsonrpc.CBOR, jsonrpc.MsgPack}, "compression")
+	rout, err := jsonrpc.NewRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rout.Request("echo", middleware.Echo)
+
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.Negotiate([]jsonrpc.Codec{jsonrpc.MsgPack, jsonrpc.CBOR}, []string{"compression", "streaming"}, time.Second*5); err != nil {
+		t.Fatal(err)
+	}
+	if name := c.Codec("").Name(); name != "msgpack" {
+		t.Fatalf("expected client to switch to msgpack, got: %v", name)
+	}
+	if !c.HasExtension("", "compression") || c.HasExtension("", "streaming") {
+		t.Fatal("expected only compression extension to be agreed on")
+	}
+
+	assertEcho(t, c, "Hello!")
+}
+
+func TestNegotiateFallback(t *testing.T) {
+	s := jsonrpc.NewPipeServer()
+	defer s.Close()
+	rout, err := jsonrpc.NewRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rout.Request("echo", middleware.Echo)
+
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// server does not accept negotiation, so rpc.negotiate is answered with method not found error
+	if err := c.Negotiate([]jsonrpc.Codec{jsonrpc.MsgPack}, nil, time.Second*5); err != nil {
+		t.Fatal(err)
+	}
+	if name := c.Codec("").Name(); name != "json" {
+		t.Fatalf("expected client to fall back to json, got: %v", name)
+	}
+
+	assertEcho(t, c, "Hello!")
+}
+
+func TestNegotiateTimeout(t *testing.T) {
+	s := jsonrpc.NewPipeServer()
+	defer s.Close()
+	// server does not answer rpc.negotiate at all
+	s.ReqMiddleware(func(ctx *jsonrpc.ReqCtx) error {
+		if ctx.Method() == "rpc.negotiate" {
+			return nil
+		}
+		return ctx.Next()
+	})
+	rout, err := jsonrpc.NewRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rout.Request("echo", middleware.Echo)
+
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	start := time.Now()
+	if err := c.Negotiate([]jsonrpc.Codec{jsonrpc.MsgPack}, nil, time.Millisecond*100); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond*100 {
+		t.Fatalf("expected negotiation to wait for the timeout, returned after %v", elapsed)
+	}
+	if name := c.Codec("").Name(); name != "json" {
+		t.Fatalf("expected client to fall back to json, got: %v", name)
+	}
+
+	assertEcho(t, c, "Hello!")
+}
+
+func TestNegotiateLateAnswer(t *testing.T) {
+	s := jsonrpc.NewPipeServer()
+	defer s.Close()
+	s.ReqMiddleware(func(ctx *jsonrpc.ReqCtx) error {
+		time.Sleep(time.Millisecond * 200)
+		return ctx.Next()
+	})
+	s.AcceptNegotiation([]jsonrpc.Codec{jsonrpc.MsgPack})
+
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.Negotiate([]jsonrpc.Codec{jsonrpc.MsgPack}, nil, time.Millisecond*50); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 300)
+	if name := c.Codec("").Name(); name != "json" {
+		t.Fatalf("expected late answer to be discarded, got codec: %v", name)
+	}
+}
+
+// assertEcho sends an echo request and waits for the same message to be returned.
+func assertEcho(t *testing.T, c *jsonrpc.Client, message string) {
+	res := make(chan string)
+	if _, err := c.SendRequest("echo", echoMsg{Message: message}, func(ctx *jsonrpc.ResCtx) error {
+		var msg echoMsg
+		if err := ctx.Result(&msg); err != nil {
+			t.Fatal(err)
+		}
+		res <- msg.Message
+		return ctx.Next()
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-res:
+		if msg != message {
+			t.Fatalf("expected: %v got: %v", message, msg)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for response")
+	}
+}
@@ -0,0 +1,48 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/neptulon/jsonrpc"
+)
+
+func TestPeerCallback(t *testing.T) {
+	s := jsonrpc.NewPipeServer()
+	defer s.Close()
+	rout, err := jsonrpc.NewRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rout.Request("greet", func(ctx *jsonrpc.ReqCtx) error {
+		var name string
+		if err := ctx.Peer.Call(ctx.Context(), "client.name", nil, &name); err != nil {
+			return err
+		}
+		ctx.Res = "hello " + name
+		return ctx.Next()
+	})
+
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Peer().HandleRequest("client.name", func(ctx *jsonrpc.ReqCtx) error {
+		ctx.Res = "jane"
+		return ctx.Next()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	var res string
+	if err := c.Peer().Call(ctx, "greet", nil, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res != "hello jane" {
+		t.Fatalf("expected: hello jane got: %v", res)
+	}
+}
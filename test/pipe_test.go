@@ -0,0 +1,71 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/neptulon/jsonrpc"
+	"github.com/neptulon/jsonrpc/middleware"
+)
+
+func TestPipeDuplex(t *testing.T) {
+	s := jsonrpc.NewPipeServer()
+	defer s.Close()
+	rout, err := jsonrpc.NewRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done, errs := make(chan string, 3), make(chan error, 2)
+	rout.Request("echo", middleware.Echo)
+	rout.Notification("hello", func(ctx *jsonrpc.NotCtx) error {
+		// server requests back from the client through the connection the notification came from
+		_, err := s.SendRequest(ctx.Client.ConnID(), "echo", echoMsg{Message: "from server"}, func(ctx *jsonrpc.ResCtx) error {
+			var msg echoMsg
+			if err := ctx.Result(&msg); err != nil {
+				errs <- err
+				return err
+			}
+			done <- msg.Message
+			return ctx.Next()
+		})
+		return err
+	})
+
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.HandleRequest("echo", middleware.Echo)
+
+	if _, err := c.SendRequest("echo", echoMsg{Message: "from client"}, func(ctx *jsonrpc.ResCtx) error {
+		var msg echoMsg
+		if err := ctx.Result(&msg); err != nil {
+			errs <- err
+			return err
+		}
+		done <- msg.Message
+		return ctx.Next()
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SendNotification("hello", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-done:
+			got[msg] = true
+		case err := <-errs:
+			t.Fatal(err)
+		case <-time.After(time.Second * 5):
+			t.Fatal("timed out waiting for responses")
+		}
+	}
+	if !got["from client"] || !got["from server"] {
+		t.Fatalf("expected echoes in both directions, got: %v", got)
+	}
+}
@@ -0,0 +1,158 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/neptulon/jsonrpc"
+)
+
+func newNamedServer(t *testing.T, name string) *jsonrpc.PipeServer {
+	s := jsonrpc.NewPipeServer()
+	rout, err := jsonrpc.NewRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rout.Request("whoami", func(ctx *jsonrpc.ReqCtx) error {
+		ctx.Res = name
+		return ctx.Next()
+	})
+	rout.Request("hang", func(ctx *jsonrpc.ReqCtx) error { return nil })
+	return s
+}
+
+func TestPool(t *testing.T) {
+	a, b := newNamedServer(t, "a"), newNamedServer(t, "b")
+	defer a.Close()
+	defer b.Close()
+
+	p, err := jsonrpc.NewPool(a.Dial, b.Dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	p.RetryInterval = time.Millisecond * 50
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	whoami := func() string {
+		var name string
+		if err := p.Call(ctx, "whoami", nil, &name); err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+
+	counts := map[string]int{}
+	for i := 0; i < 4; i++ {
+		counts[whoami()]++
+	}
+	if counts["a"] != 2 || counts["b"] != 2 {
+		t.Fatalf("expected round-robin across connections, got: %v", counts)
+	}
+
+	b.Close()
+	for i := 0; i < 4; i++ {
+		if name := whoami(); name != "a" {
+			t.Fatalf("expected failed connection to be taken out, got response from: %v", name)
+		}
+	}
+
+	for whoami() != "b" {
+		if ctx.Err() != nil {
+			t.Fatal("failed connection was not added back")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	p.Balancing = jsonrpc.LeastPending
+	if _, err := p.SendRequest("hang", nil, func(ctx *jsonrpc.ResCtx) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	idle := ""
+	for i := 0; i < 3; i++ {
+		name := whoami()
+		if idle == "" {
+			idle = name
+		} else if name != idle {
+			t.Fatalf("expected the idle connection to be used, got: %v and %v", idle, name)
+		}
+	}
+}
+
+func TestPoolConnLost(t *testing.T) {
+	s := newNamedServer(t, "a")
+	p, err := jsonrpc.NewPool(s.Dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	errs := make(chan *jsonrpc.ResError, 1)
+	if _, err := p.SendRequest("hang", nil, func(ctx *jsonrpc.ResCtx) error {
+		errs <- ctx.Err
+		return ctx.Next()
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Close()
+	if err := p.SendNotification("whoami", nil); err == nil {
+		t.Fatal("expected sending through the closed connection to fail")
+	}
+
+	select {
+	case err := <-errs:
+		if err == nil || err.Code != jsonrpc.ConnLostCode {
+			t.Fatalf("expected connection lost error, got: %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for pending request to fail")
+	}
+}
+
+func TestPoolDisconnected(t *testing.T) {
+	a, b := newNamedServer(t, "a"), newNamedServer(t, "b")
+	defer a.Close()
+	defer b.Close()
+
+	p, err := jsonrpc.NewPool(a.Dial, b.Dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	p.RetryInterval = time.Hour
+	p.Balancing = jsonrpc.LeastPending
+
+	// one request waits on each connection
+	errs := make(chan *jsonrpc.ResError, 2)
+	for i := 0; i < 2; i++ {
+		if _, err := p.SendRequest("hang", nil, func(ctx *jsonrpc.ResCtx) error {
+			errs <- ctx.Err
+			return ctx.Next()
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// server closing the connection fails its pending request without anything else being sent through the pool
+	b.Close()
+	select {
+	case err := <-errs:
+		if err == nil || err.Code != jsonrpc.ConnLostCode {
+			t.Fatalf("expected connection lost error, got: %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for pending request of the disconnected connection to fail")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		var name string
+		if err := p.Call(ctx, "whoami", nil, &name); err != nil || name != "a" {
+			t.Fatalf("expected the live connection to be used, got: %v, %v", name, err)
+		}
+	}
+}
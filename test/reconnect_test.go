@@ -0,0 +1,150 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/neptulon/jsonrpc"
+)
+
+func TestReconnectingClient(t *testing.T) {
+	s := jsonrpc.NewPipeServer()
+	defer s.Close()
+	rout, err := jsonrpc.NewRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var logins int32
+	release := make(chan struct{})
+	rout.Request("login", func(ctx *jsonrpc.ReqCtx) error {
+		atomic.AddInt32(&logins, 1)
+		ctx.Res = "ok"
+		return ctx.Next()
+	})
+	slow := func(ctx *jsonrpc.ReqCtx) error {
+		<-release
+		ctx.Res = "done"
+		return ctx.Next()
+	}
+	rout.Request("slow.read", slow)
+	rout.Request("slow.write", slow)
+
+	rc, err := jsonrpc.NewReconnectingClient(s.Dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	rc.MinBackoff = time.Millisecond * 10
+	rc.Idempotent("slow.read")
+
+	var responses int32
+	rc.ResMiddleware(func(ctx *jsonrpc.ResCtx) error {
+		atomic.AddInt32(&responses, 1)
+		return ctx.Next()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	var missed int32
+	if err := rc.OnConnect(func(c *jsonrpc.Client) error {
+		n := atomic.LoadInt32(&responses)
+		if err := c.Call(ctx, "login", nil, nil); err != nil {
+			return err
+		}
+		if atomic.LoadInt32(&responses) == n {
+			atomic.StoreInt32(&missed, 1)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	read, write := make(chan error, 1), make(chan *jsonrpc.ResCtx, 1)
+	go func() { read <- rc.Call(ctx, "slow.read", nil, nil) }()
+	if _, err := rc.SendRequest("slow.write", nil, func(ctx *jsonrpc.ResCtx) error {
+		write <- ctx
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 50) // let the requests reach the server
+
+	s.Close()
+	select {
+	case res := <-write:
+		if res.Err == nil || res.Err.Code != jsonrpc.ConnLostCode {
+			t.Fatalf("expected connection lost error, got: %v", res.Err)
+		}
+		if res.Client == nil {
+			t.Fatal("expected the lost connection to be set as the client of the response")
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for in flight request to fail")
+	}
+
+	for atomic.LoadInt32(&logins) != 2 {
+		if ctx.Err() != nil {
+			t.Fatal("setup hooks were not run after reconnecting")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	close(release)
+	select {
+	case err := <-read:
+		if err != nil {
+			t.Fatalf("expected idempotent request to be re-sent, got: %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for re-sent request")
+	}
+
+	if atomic.LoadInt32(&missed) != 0 {
+		t.Fatal("expected middleware to be registered on the new connection before the setup hooks run")
+	}
+}
+
+func TestReconnectingClientClosedWhileReconnecting(t *testing.T) {
+	s := jsonrpc.NewPipeServer()
+	defer s.Close()
+
+	var dials int32
+	rc, err := jsonrpc.NewReconnectingClient(func() (*jsonrpc.Client, error) {
+		if atomic.AddInt32(&dials, 1) > 1 {
+			return nil, errors.New("server is down")
+		}
+		return s.Dial()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.MinBackoff = time.Millisecond * 10
+	rc.Idempotent("read")
+
+	s.Close()
+	deadline := time.Now().Add(time.Second * 5)
+	for rc.Client() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for connection to be lost")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	res := make(chan *jsonrpc.ResCtx, 1)
+	if _, err := rc.SendRequest("read", nil, func(ctx *jsonrpc.ResCtx) error {
+		res <- ctx
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+
+	ctx := <-res
+	if ctx.Err == nil || ctx.Err.Code != jsonrpc.ConnLostCode || ctx.Client != nil {
+		t.Fatalf("expected connection lost error without a client for a request that was never sent, got: %v, %v", ctx.Err, ctx.Client)
+	}
+}
@@ -0,0 +1,347 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/neptulon/jsonrpc"
+)
+
+func TestRouterGroup(t *testing.T) {
+	s := jsonrpc.NewPipeServer()
+	defer s.Close()
+	rout, err := jsonrpc.NewRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rout.Request("ping", func(ctx *jsonrpc.ReqCtx) error {
+		ctx.Res = "pong"
+		return ctx.Next()
+	})
+
+	// only the methods in the users namespace require auth
+	users := rout.Group("users.")
+	users.ReqMiddleware(func(ctx *jsonrpc.ReqCtx) error {
+		if token, _ := ctx.Meta()["token"]; token != "secret" {
+			ctx.Err = &jsonrpc.ResError{Code: 401, Message: "Unauthorized"}
+			return ctx.Respond()
+		}
+		return ctx.Next()
+	})
+	users.Request("get", func(ctx *jsonrpc.ReqCtx) error {
+		ctx.Res = "user for " + ctx.Method()
+		return ctx.Next()
+	})
+
+	// separately owned router mounted under a nested namespace
+	admin, err := jsonrpc.NewRouter(users.Group("admin."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin.Request("purge", func(ctx *jsonrpc.ReqCtx) error {
+		ctx.Res = "purged"
+		return ctx.Next()
+	})
+	ordersRout, err := jsonrpc.NewRouter(new(jsonrpc.Middleware)) // standalone router, which is not served on its own
+	if err != nil {
+		t.Fatal(err)
+	}
+	ordersRout.Request("list", func(ctx *jsonrpc.ReqCtx) error {
+		ctx.Res = "orders"
+		return ctx.Next()
+	})
+	rout.Mount("orders.", ordersRout)
+
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	authCtx := jsonrpc.ContextWithMeta(ctx, map[string]string{"token": "secret"})
+
+	var res string
+	if err := c.Call(ctx, "ping", nil, &res); err != nil || res != "pong" {
+		t.Fatalf("expected pong without auth, got: %v, %v", res, err)
+	}
+	if err := c.Call(ctx, "users.get", nil, &res); err == nil || err.(*jsonrpc.ResError).Code != 401 {
+		t.Fatalf("expected unauthorized error, got: %v", err)
+	}
+	if err := c.Call(authCtx, "users.get", nil, &res); err != nil || res != "user for users.get" {
+		t.Fatalf("expected user, got: %v, %v", res, err)
+	}
+	if err := c.Call(ctx, "users.admin.purge", nil, &res); err == nil {
+		t.Fatal("expected nested namespace to inherit auth middleware")
+	}
+	if err := c.Call(authCtx, "users.admin.purge", nil, &res); err != nil || res != "purged" {
+		t.Fatalf("expected purged, got: %v, %v", res, err)
+	}
+	if err := c.Call(ctx, "orders.list", nil, &res); err != nil || res != "orders" {
+		t.Fatalf("expected orders, got: %v, %v", res, err)
+	}
+
+	var doc jsonrpc.OpenRPC
+	if err := c.Call(ctx, "rpc.discover", nil, &doc); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, m := range doc.Methods {
+		names = append(names, m.Name)
+	}
+	if len(names) != 4 || names[0] != "orders.list" || names[1] != "ping" || names[2] != "users.admin.purge" || names[3] != "users.get" {
+		t.Fatalf("expected mounted routes to be discovered with prefixes, got: %v", names)
+	}
+}
+
+func TestRouteMiddleware(t *testing.T) {
+	s := jsonrpc.NewPipeServer()
+	defer s.Close()
+	rout, err := jsonrpc.NewRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls []string
+	trace := func(name string) func(ctx *jsonrpc.ReqCtx) error {
+		return func(ctx *jsonrpc.ReqCtx) error {
+			calls = append(calls, name)
+			return ctx.Next()
+		}
+	}
+	adminOnly := func(ctx *jsonrpc.ReqCtx) error {
+		if ctx.Meta()["role"] != "admin" {
+			ctx.Err = &jsonrpc.ResError{Code: 403, Message: "Forbidden"}
+			return ctx.Respond()
+		}
+		return ctx.Next()
+	}
+
+	rout.Request("delete", func(ctx *jsonrpc.ReqCtx) error {
+		calls = append(calls, "handler")
+		ctx.Res = "deleted"
+		return ctx.Next()
+	}, trace("first"), adminOnly, trace("second"))
+	rout.Request("list", func(ctx *jsonrpc.ReqCtx) error {
+		calls = append(calls, "list")
+		ctx.Res = "listed"
+		return ctx.Next()
+	})
+	s.ReqMiddleware(trace("after"))
+
+	notified := make(chan string, 1)
+	rout.Notification("event", func(ctx *jsonrpc.NotCtx) error {
+		var name string
+		ctx.Params(&name)
+		notified <- name
+		return ctx.Next()
+	}, func(ctx *jsonrpc.NotCtx) error {
+		var name string
+		if err := ctx.Params(&name); err != nil || name == "dropped" {
+			return nil
+		}
+		return ctx.Next()
+	})
+
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	adminCtx := jsonrpc.ContextWithMeta(ctx, map[string]string{"role": "admin"})
+
+	if err := c.Call(ctx, "list", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Call(ctx, "delete", nil, nil); err == nil || err.(*jsonrpc.ResError).Code != 403 {
+		t.Fatalf("expected forbidden error, got: %v", err)
+	}
+	if err := c.Call(adminCtx, "delete", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(calls), "[list after first first second handler after]"; got != want {
+		t.Fatalf("expected middleware calls %v, got: %v", want, got)
+	}
+
+	if err := c.SendNotification("event", "dropped"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SendNotification("event", "passed"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case name := <-notified:
+		if name != "passed" {
+			t.Fatalf("expected only the filtered notification to pass, got: %v", name)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for notification")
+	}
+}
+
+func TestRoutePatterns(t *testing.T) {
+	s := jsonrpc.NewPipeServer()
+	defer s.Close()
+	rout, err := jsonrpc.NewRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handle := func(name string) func(ctx *jsonrpc.ReqCtx) error {
+		return func(ctx *jsonrpc.ReqCtx) error {
+			ctx.Res = fmt.Sprintf("%v room=%v rest=%v", name, ctx.Capture("room"), ctx.Capture("*"))
+			return ctx.Next()
+		}
+	}
+	rout.Request("*", handle("catch-all"))
+	rout.Request("events.{room}.post", handle("post"))
+	rout.Request("events.lobby.post", handle("lobby"))
+	rout.Request("events.{room}.*", handle("room"))
+	rout.Request("chat.*", handle("chat"))
+	rout.Group("chat.admin.").Request("{room}", handle("admin"))
+
+	notified := make(chan string, 1)
+	rout.Notification("events.{room}.*", func(ctx *jsonrpc.NotCtx) error {
+		notified <- ctx.Capture("room") + " " + ctx.Capture("*")
+		return ctx.Next()
+	})
+
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	for method, want := range map[string]string{
+		"events.lobby.post":       "lobby room= rest=",
+		"events.kitchen.post":     "post room=kitchen rest=",
+		"events.kitchen.edit.all": "room room=kitchen rest=edit.all",
+		"chat.send":               "chat room= rest=send",
+		"chat.admin.ops":          "admin room=ops rest=",
+		"events.kitchen":          "catch-all room= rest=events.kitchen",
+		"unknown":                 "catch-all room= rest=unknown",
+	} {
+		var res string
+		if err := c.Call(ctx, method, nil, &res); err != nil {
+			t.Fatalf("%v: %v", method, err)
+		}
+		if res != want {
+			t.Fatalf("%v: expected %q, got: %q", method, want, res)
+		}
+	}
+
+	var doc jsonrpc.OpenRPC
+	if err := c.Call(ctx, "rpc.discover", nil, &doc); err != nil || len(doc.Methods) == 0 {
+		t.Fatalf("expected catch-all not to handle rpc.discover, got: %+v, %v", doc, err)
+	}
+
+	if err := c.SendNotification("events.kitchen.joined", nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-notified:
+		if got != "kitchen joined" {
+			t.Fatalf("expected captures of notification pattern, got: %v", got)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for notification")
+	}
+}
+
+func TestNotificationSubscribers(t *testing.T) {
+	s := jsonrpc.NewPipeServer()
+	defer s.Close()
+	if _, err := jsonrpc.NewRouter(s); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	events := make(chan string, 10)
+	unsubscribeA := c.Subscribe("news", func(ctx *jsonrpc.NotCtx) error {
+		events <- "a"
+		return ctx.Next()
+	})
+	c.Subscribe("news", func(ctx *jsonrpc.NotCtx) error {
+		return errors.New("failing listener")
+	})
+	c.Subscribe("news", func(ctx *jsonrpc.NotCtx) error {
+		panic("panicking listener")
+	})
+	c.Subscribe("news", func(ctx *jsonrpc.NotCtx) error {
+		events <- "b"
+		return ctx.Next()
+	})
+	c.HandleNotification("news", func(ctx *jsonrpc.NotCtx) error {
+		events <- "handler"
+		return ctx.Next()
+	})
+
+	expect := func(want ...string) {
+		for _, w := range want {
+			select {
+			case got := <-events:
+				if got != w {
+					t.Fatalf("expected %v, got: %v", w, got)
+				}
+			case <-time.After(time.Second * 5):
+				t.Fatalf("timed out waiting for %v", w)
+			}
+		}
+	}
+
+	// server pushes a notification to the client
+	var connID string
+	s.ReqMiddleware(func(ctx *jsonrpc.ReqCtx) error {
+		connID = ctx.Client.ConnID()
+		ctx.Res = true
+		return ctx.Next()
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := c.Call(ctx, "hello", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.SendNotification(connID, "news", nil); err != nil {
+		t.Fatal(err)
+	}
+	expect("a", "b", "handler")
+
+	unsubscribeA()
+	unsubscribeA() // idempotent
+	if err := s.SendNotification(connID, "news", nil); err != nil {
+		t.Fatal(err)
+	}
+	expect("b", "handler")
+}
+
+func TestRouterResponseMiddleware(t *testing.T) {
+	// responses are not routed by method name, so routers accept the middleware of requests and notifications only
+	rout, err := jsonrpc.NewRouter(new(jsonrpc.Middleware))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var group interface{} = rout.Group("users.")
+	if _, ok := group.(jsonrpc.MiddlewareHandler); ok {
+		t.Fatal("expected router not to accept response middleware")
+	}
+	if _, ok := group.(jsonrpc.RouteHandler); !ok {
+		t.Fatal("expected router to accept request and notification middleware")
+	}
+}
@@ -0,0 +1,153 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/neptulon/jsonrpc"
+)
+
+func TestShutdown(t *testing.T) {
+	s := jsonrpc.NewPipeServer()
+	s.NotifyShutdown = true
+	rout, err := jsonrpc.NewRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	started, release := make(chan struct{}), make(chan struct{})
+	rout.Request("slow", func(ctx *jsonrpc.ReqCtx) error {
+		close(started)
+		<-release
+		ctx.Res = "done"
+		return ctx.Next()
+	})
+	rout.Request("fast", func(ctx *jsonrpc.ReqCtx) error {
+		ctx.Res = "done"
+		return ctx.Next()
+	})
+
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	notified := make(chan struct{})
+	c.HandleNotification(jsonrpc.ShutdownMethod, func(ctx *jsonrpc.NotCtx) error {
+		close(notified)
+		return ctx.Next()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	slow := make(chan error, 1)
+	go func() { slow <- c.Call(ctx, "slow", nil, nil) }()
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(ctx) }()
+	select {
+	case <-notified:
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for shutdown notification")
+	}
+
+	if err := c.Call(ctx, "fast", nil, nil); err == nil || err.(*jsonrpc.ResError).Code != jsonrpc.ShutdownCode {
+		t.Fatalf("expected shutting down error, got: %v", err)
+	}
+	select {
+	case err := <-shutdown:
+		t.Fatalf("expected shutdown to wait for running handlers, returned: %v", err)
+	default:
+	}
+
+	close(release)
+	if err := <-slow; err != nil {
+		t.Fatalf("expected running request to be answered, got: %v", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-c.Done():
+	case <-ctx.Done():
+		t.Fatal("expected connection to be closed after shutdown")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	s := jsonrpc.NewPipeServer()
+	rout, err := jsonrpc.NewRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	rout.Request("hang", func(ctx *jsonrpc.ReqCtx) error {
+		close(started)
+		<-release
+		return nil
+	})
+
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.SendRequest("hang", nil, func(ctx *jsonrpc.ResCtx) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline to be exceeded, got: %v", err)
+	}
+	<-c.Done()
+}
+
+func TestShutdownHold(t *testing.T) {
+	s := jsonrpc.NewPipeServer()
+	rout, err := jsonrpc.NewRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	started, release := make(chan struct{}), make(chan struct{})
+	rout.Request("async", func(ctx *jsonrpc.ReqCtx) error {
+		done := ctx.Hold()
+		go func() {
+			defer done()
+			<-release
+			ctx.Res = "done"
+			ctx.Respond()
+		}()
+		close(started)
+		return nil
+	})
+
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	async := make(chan error, 1)
+	go func() { async <- c.Call(ctx, "async", nil, nil) }()
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(ctx) }()
+	select {
+	case err := <-shutdown:
+		t.Fatalf("expected shutdown to wait for held requests, returned: %v", err)
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	close(release)
+	if err := <-async; err != nil {
+		t.Fatalf("expected held request to be answered, got: %v", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,146 @@
+package test
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neptulon/jsonrpc"
+	"github.com/neptulon/jsonrpc/middleware"
+)
+
+// pipeRWC joins the read end of one pipe and the write end of another into a single stream.
+type pipeRWC struct {
+	*io.PipeReader
+	*io.PipeWriter
+}
+
+func (p pipeRWC) Close() error {
+	p.PipeReader.Close()
+	return p.PipeWriter.Close()
+}
+
+func TestStreamPeerDuplex(t *testing.T) {
+	r1, w1 := io.Pipe()
+	r2, w2 := io.Pipe()
+
+	server, err := jsonrpc.NewStreamPeer(pipeRWC{r1, w2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := jsonrpc.NewStreamPeer(pipeRWC{r2, w1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.HandleRequest("echo", middleware.Echo)
+	client.HandleRequest("echo", middleware.Echo)
+	go server.Serve()
+	go client.Serve()
+	defer client.Close()
+	defer server.Close()
+
+	res, errs := make(chan string, 2), make(chan error, 2)
+	handler := func(ctx *jsonrpc.ResCtx) error {
+		var msg echoMsg
+		if err := ctx.Result(&msg); err != nil {
+			errs <- err
+			return err
+		}
+		res <- msg.Message
+		return ctx.Next()
+	}
+
+	if _, err := client.SendRequest("echo", echoMsg{Message: "Hello!"}, handler); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.SendRequest("echo", echoMsg{Message: "Hello!"}, handler); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-res:
+			if msg != "Hello!" {
+				t.Fatalf("expected: %v got: %v", "Hello!", msg)
+			}
+		case err := <-errs:
+			t.Fatal(err)
+		case <-time.After(time.Second * 5):
+			t.Fatal("timed out waiting for response")
+		}
+	}
+}
+
+func TestStreamPeerMaxMessageSize(t *testing.T) {
+	r, w := io.Pipe()
+	_, w2 := io.Pipe()
+
+	p, err := jsonrpc.NewStreamPeer(pipeRWC{r, w2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.MaxMessageSize = 16
+
+	errs := make(chan error, 1)
+	go func() { errs <- p.Serve() }()
+	go w.Write([]byte("Content-Length: 1073741824\r\n\r\n"))
+	defer p.Close()
+
+	select {
+	case err := <-errs:
+		if err == nil || !strings.Contains(err.Error(), "maximum message size") {
+			t.Fatalf("expected maximum message size error, got: %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for oversized message to be rejected")
+	}
+}
+
+func TestStreamPeerForgetsPeer(t *testing.T) {
+	r1, w1 := io.Pipe()
+	r2, w2 := io.Pipe()
+
+	server, err := jsonrpc.NewStreamPeer(pipeRWC{r1, w2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := jsonrpc.NewStreamPeer(pipeRWC{r2, w1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peers := make(chan *jsonrpc.Peer, 1)
+	server.HandleRequest("hello", func(ctx *jsonrpc.ReqCtx) error {
+		peers <- ctx.Peer
+		ctx.Res = "hi"
+		return ctx.Next()
+	})
+	served := make(chan error, 1)
+	go func() { served <- server.Serve() }()
+	go client.Serve()
+
+	if _, err := client.SendRequest("hello", nil, func(ctx *jsonrpc.ResCtx) error { return ctx.Next() }); err != nil {
+		t.Fatal(err)
+	}
+
+	var peer *jsonrpc.Peer
+	select {
+	case peer = <-peers:
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for request")
+	}
+
+	client.Close()
+	select {
+	case <-served:
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for server to stop serving")
+	}
+	select {
+	case <-peer.Context().Done():
+	default:
+		t.Fatal("expected the peer of the closed connection to be forgotten")
+	}
+}
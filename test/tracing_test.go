@@ -0,0 +1,90 @@
+package test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neptulon/jsonrpc"
+	"github.com/neptulon/jsonrpc/middleware"
+)
+
+func TestTracing(t *testing.T) {
+	back := jsonrpc.NewPipeServer()
+	defer back.Close()
+	if _, err := middleware.NewTracing(back); err != nil {
+		t.Fatal(err)
+	}
+	brout, err := jsonrpc.NewRouter(back)
+	if err != nil {
+		t.Fatal(err)
+	}
+	brout.Request("back", func(ctx *jsonrpc.ReqCtx) error {
+		ctx.Res = ctx.Meta()[middleware.TraceparentKey]
+		return ctx.Next()
+	})
+	bc, err := back.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+
+	front := jsonrpc.NewPipeServer()
+	defer front.Close()
+	tracing, err := middleware.NewTracing(front)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spans := make(chan *middleware.Span, 1)
+	tracing.OnSpan = func(span *middleware.Span) { spans <- span }
+	frout, err := jsonrpc.NewRouter(front)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frout.Request("front", func(ctx *jsonrpc.ReqCtx) error {
+		var tp string
+		if err := bc.Call(ctx.Context(), "back", nil, &tp); err != nil {
+			return err
+		}
+		ctx.Res = tp
+		return ctx.Next()
+	})
+
+	c, err := front.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	traceID, parentID := "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	var tp string
+	if err := c.Call(jsonrpc.ContextWithMeta(ctx, map[string]string{"traceparent": "00-" + traceID + "-" + parentID + "-01"}), "front", nil, &tp); err != nil {
+		t.Fatal(err)
+	}
+
+	var span *middleware.Span
+	select {
+	case span = <-spans:
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for span")
+	}
+
+	if span.TraceID != traceID || span.ParentID != parentID || !span.Sampled || span.Method != "front" {
+		t.Fatalf("unexpected span: %+v", span)
+	}
+	if tp != "00-"+traceID+"-"+span.SpanID+"-01" {
+		t.Fatalf("expected trace to be propagated to the backend as a child of %v, got: %v", span.SpanID, tp)
+	}
+
+	// requests without a trace start a new one
+	if err := c.Call(ctx, "front", nil, &tp); err != nil {
+		t.Fatal(err)
+	}
+	span = <-spans
+	if span.ParentID != "" || len(span.TraceID) != 32 || !strings.Contains(tp, span.TraceID) {
+		t.Fatalf("expected a new trace, got span: %+v and backend traceparent: %v", span, tp)
+	}
+}
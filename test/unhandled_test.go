@@ -0,0 +1,106 @@
+package test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neptulon/jsonrpc"
+)
+
+func TestUnhandledHooks(t *testing.T) {
+	s := jsonrpc.NewPipeServer()
+	defer s.Close()
+	rout, err := jsonrpc.NewRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rout.Request("known", func(ctx *jsonrpc.ReqCtx) error {
+		ctx.Res = "ok"
+		return ctx.Next()
+	})
+
+	letters := make(chan string, 10)
+	s.UseDeadLetter(func(connID string, msg []byte) {
+		letters <- string(msg)
+	})
+	hooks := make(chan string, 10)
+	s.UnhandledRequest(func(ctx *jsonrpc.ReqCtx) error {
+		hooks <- "request " + ctx.Method()
+		return ctx.Next()
+	})
+	s.UnhandledRequest(func(ctx *jsonrpc.ReqCtx) error {
+		if ctx.Method() == "legacy" {
+			ctx.Res = "handled by fallback"
+			return ctx.Respond()
+		}
+		return ctx.Next()
+	})
+	s.UnhandledNotification(func(ctx *jsonrpc.NotCtx) error {
+		hooks <- "notification " + ctx.Method()
+		return ctx.Next()
+	})
+	s.UnhandledResponse(func(ctx *jsonrpc.ResCtx) error {
+		hooks <- "response"
+		return ctx.Next()
+	})
+
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	expect := func(ch chan string, want string) {
+		select {
+		case got := <-ch:
+			if !strings.Contains(got, want) {
+				t.Fatalf("expected %v, got: %v", want, got)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for %v", want)
+		}
+	}
+
+	if err := c.Call(ctx, "known", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Call(ctx, "unknown", nil, nil); err == nil || err.(*jsonrpc.ResError).Code != -32601 {
+		t.Fatalf("expected method not found error, got: %v", err)
+	}
+	expect(letters, `"method":"unknown"`)
+	expect(hooks, "request unknown")
+
+	var res string
+	if err := c.Call(ctx, "legacy", nil, &res); err != nil || res != "handled by fallback" {
+		t.Fatalf("expected hook to answer the request, got: %v, %v", res, err)
+	}
+	expect(hooks, "request legacy") // answered by a hook, so it is not a dead letter
+
+	if err := c.SendNotification("nobody.listens", nil); err != nil {
+		t.Fatal(err)
+	}
+	expect(letters, `"method":"nobody.listens"`)
+	expect(hooks, "notification nobody.listens")
+
+	if err := c.SendResponse("no-such-request", "late", nil); err != nil {
+		t.Fatal(err)
+	}
+	expect(letters, `"id":"no-such-request"`)
+	expect(hooks, "response")
+
+	select {
+	case h := <-hooks:
+		t.Fatalf("expected handled messages not to reach the hooks, got: %v", h)
+	default:
+	}
+	select {
+	case l := <-letters:
+		t.Fatalf("expected no more dead letters, got: %v", l)
+	default:
+	}
+}
@@ -0,0 +1,167 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/neptulon/jsonrpc"
+)
+
+//go:generate go run ../cmd/jsonrpc-gen -type UserService -prefix users
+
+type UserService interface {
+	Get(ctx context.Context, id string) (*user, error)
+	Rename(ctx context.Context, id, name string) error
+}
+
+type userStore struct {
+	mutex sync.Mutex
+	users map[string]*user
+}
+
+func (s *userStore) Get(ctx context.Context, id string) (*user, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return nil, &jsonrpc.ResError{Code: 404, Message: "User not found"}
+	}
+	return u, nil
+}
+
+func (s *userStore) Rename(ctx context.Context, id, name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return errors.New("no such user")
+	}
+	u.Name = name
+	return nil
+}
+
+func TestGeneratedService(t *testing.T) {
+	s := jsonrpc.NewPipeServer()
+	defer s.Close()
+	rout, err := jsonrpc.NewRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterUserService(rout, &userStore{users: map[string]*user{"1": {ID: "1", Name: "Jane"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	svc := NewUserServiceClient(c)
+
+	if err := svc.Rename(ctx, "1", "Joe"); err != nil {
+		t.Fatal(err)
+	}
+	u, err := svc.Get(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != "1" || u.Name != "Joe" {
+		t.Fatalf("unexpected user: %+v", u)
+	}
+
+	if _, err := svc.Get(ctx, "2"); err == nil || err.(*jsonrpc.ResError).Code != 404 {
+		t.Fatalf("expected 404 error, got: %v", err)
+	}
+	if err := svc.Rename(ctx, "2", "Joe"); err == nil || err.(*jsonrpc.ResError).Code != -32603 {
+		t.Fatalf("expected internal error, got: %v", err)
+	}
+
+	if err := c.Call(ctx, "nothing.here", nil, nil); err == nil || err.(*jsonrpc.ResError).Code != -32601 {
+		t.Fatalf("expected method not found error, got: %v", err)
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+	rout.Request("block", func(ctx *jsonrpc.ReqCtx) error {
+		<-release
+		return ctx.Next()
+	})
+	short, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	if err := c.Call(short, "block", nil, nil); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline to be exceeded, got: %v", err)
+	}
+}
+
+// TestGeneratedServiceUpToDate fails if userservice_jsonrpc_test.go is stale, which is fixed by running go generate.
+func TestGeneratedServiceUpToDate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping code generation in short mode")
+	}
+
+	out := filepath.Join(t.TempDir(), "userservice_jsonrpc_test.go")
+	cmd := exec.Command("go", "run", "../cmd/jsonrpc-gen", "-type", "UserService", "-prefix", "users", "-output", out, "userservice_test.go")
+	if msg, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("cannot run jsonrpc-gen: %v\n%s", err, msg)
+	}
+
+	want, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile("userservice_jsonrpc_test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("userservice_jsonrpc_test.go is out of date, run go generate")
+	}
+}
+
+func TestRequestContextCanceled(t *testing.T) {
+	s := jsonrpc.NewPipeServer()
+	defer s.Close()
+	rout, err := jsonrpc.NewRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started, canceled := make(chan struct{}), make(chan struct{})
+	rout.Request("block", func(ctx *jsonrpc.ReqCtx) error {
+		close(started)
+		<-ctx.Context().Done()
+		close(canceled)
+		return nil
+	})
+
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.SendRequest("block", nil, func(ctx *jsonrpc.ResCtx) error { return ctx.Next() }); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-started:
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for request")
+	}
+	c.Close()
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Second * 5):
+		t.Fatal("expected request context to be canceled when the connection is closed")
+	}
+}
@@ -0,0 +1,172 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/neptulon/jsonrpc"
+	"github.com/neptulon/jsonrpc/middleware"
+)
+
+func TestValidator(t *testing.T) {
+	s := jsonrpc.NewPipeServer()
+	defer s.Close()
+	val, err := jsonrpc.NewValidator(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rout, err := jsonrpc.NewRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := jsonrpc.SchemaOf(user{})
+	min := 3
+	schema.Properties["name"].MinLength = &min
+	if err := val.Request("users.create", schema); err != nil {
+		t.Fatal(err)
+	}
+	rout.Request("users.create", middleware.Echo)
+
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	errs := make(chan *jsonrpc.ResError)
+	params := map[string]interface{}{"name": "Al", "tags": []interface{}{"admin", 42}}
+	if _, err := c.SendRequest("users.create", params, func(ctx *jsonrpc.ResCtx) error {
+		var res interface{}
+		if err := ctx.Result(&res); err != nil || res != nil {
+			t.Fatalf("expected handler not to run, got result: %v", res)
+		}
+		errs <- ctx.Err
+		return ctx.Next()
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var resErr *jsonrpc.ResError
+	select {
+	case resErr = <-errs:
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for response")
+	}
+
+	if resErr == nil || resErr.Code != -32602 {
+		t.Fatalf("expected invalid params error, got: %v", resErr)
+	}
+	violations, _ := resErr.Data.([]interface{})
+	paths := map[string]bool{}
+	for _, v := range violations {
+		paths[v.(map[string]interface{})["path"].(string)] = true
+	}
+	if len(paths) != 3 || !paths["/id"] || !paths["/name"] || !paths["/tags/1"] {
+		t.Fatalf("unexpected violations: %v", resErr.Data)
+	}
+
+	assertEchoParams(t, c, "users.create", user{ID: "1", Name: "Jane"})
+}
+
+func TestValidatorPattern(t *testing.T) {
+	s := jsonrpc.NewPipeServer()
+	defer s.Close()
+	val, err := jsonrpc.NewValidator(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rout, err := jsonrpc.NewRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := jsonrpc.SchemaOf(user{})
+	schema.Properties["email"].Pattern = "("
+	if err := val.Request("users.create", schema); err == nil {
+		t.Fatal("expected schema with invalid pattern to be rejected")
+	}
+	schema.Properties["email"].Pattern = "^[^@]+@[^@]+$"
+	if err := val.Request("users.create", schema); err != nil {
+		t.Fatal(err)
+	}
+	rout.Request("users.create", middleware.Echo)
+
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	err = c.Call(ctx, "users.create", user{ID: "1", Name: "Jane", Email: "jane"}, nil)
+	if resErr, ok := err.(*jsonrpc.ResError); !ok || resErr.Code != -32602 {
+		t.Fatalf("expected invalid params error, got: %v", err)
+	}
+
+	assertEchoParams(t, c, "users.create", user{ID: "1", Name: "Jane", Email: "jane@example.com"})
+}
+
+func TestValidatorGroup(t *testing.T) {
+	s := jsonrpc.NewPipeServer()
+	defer s.Close()
+	rout, err := jsonrpc.NewRouter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// schemas are registered with the routes relative to the group prefix, as the handlers are
+	users := rout.Group("users.")
+	val, err := jsonrpc.NewValidator(users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := val.Request("create", jsonrpc.SchemaOf(user{})); err != nil {
+		t.Fatal(err)
+	}
+	users.Request("create", middleware.Echo)
+
+	c, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	err = c.Call(ctx, "users.create", map[string]interface{}{"name": 42}, nil)
+	if resErr, ok := err.(*jsonrpc.ResError); !ok || resErr.Code != -32602 {
+		t.Fatalf("expected invalid params error, got: %v", err)
+	}
+
+	assertEchoParams(t, c, "users.create", user{ID: "1", Name: "Jane"})
+}
+
+// assertEchoParams sends a request which is expected to be answered with the same params.
+func assertEchoParams(t *testing.T, c *jsonrpc.Client, method string, params user) {
+	res := make(chan user)
+	if _, err := c.SendRequest(method, params, func(ctx *jsonrpc.ResCtx) error {
+		if err := ctx.Err; err != nil {
+			t.Fatal(err)
+		}
+		var u user
+		if err := ctx.Result(&u); err != nil {
+			t.Fatal(err)
+		}
+		res <- u
+		return ctx.Next()
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case u := <-res:
+		if u.ID != params.ID || u.Name != params.Name {
+			t.Fatalf("expected: %+v got: %+v", params, u)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for response")
+	}
+}
@@ -0,0 +1,232 @@
+package test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/neptulon/jsonrpc"
+	"github.com/neptulon/jsonrpc/middleware"
+)
+
+func TestWebSocketEcho(t *testing.T) {
+	s := jsonrpc.NewWebSocketServer(time.Second)
+	rout, err := jsonrpc.NewRouter(&s.Middleware)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rout.Request("echo", middleware.Echo)
+
+	hs := httptest.NewServer(s)
+	defer hs.Close()
+	defer s.Close()
+
+	c := jsonrpc.NewWebSocketClient(time.Second, nil)
+	if err := c.Connect("ws"+strings.TrimPrefix(hs.URL, "http"), nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	res, errs := make(chan string, 1), make(chan error, 1)
+	_, err = c.SendRequest("echo", echoMsg{Message: "Hello!"}, func(ctx *jsonrpc.ResCtx) error {
+		var msg echoMsg
+		if err := ctx.Result(&msg); err != nil {
+			errs <- err
+			return err
+		}
+		res <- msg.Message
+		return ctx.Next()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-res:
+		if msg != "Hello!" {
+			t.Fatalf("expected: %v got: %v", "Hello!", msg)
+		}
+	case err := <-errs:
+		t.Fatal(err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for response")
+	}
+}
+
+func TestWebSocketClientNotConnected(t *testing.T) {
+	c := jsonrpc.NewWebSocketClient(0, nil)
+	if _, err := c.SendRequest("echo", nil, func(ctx *jsonrpc.ResCtx) error { return ctx.Next() }); err == nil {
+		t.Fatal("expected error sending request before connecting")
+	}
+	if c.ConnID() != "" {
+		t.Fatalf("expected empty connection ID before connecting, got: %v", c.ConnID())
+	}
+	c.Session().Set("user", "jane")
+	if c.Session().Get("user") != "jane" {
+		t.Fatal("expected session to be usable before connecting")
+	}
+}
+
+func TestWebSocketHandlerCallback(t *testing.T) {
+	s := jsonrpc.NewWebSocketServer(time.Second)
+	rout, err := jsonrpc.NewRouter(&s.Middleware)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rout.Request("greet", func(ctx *jsonrpc.ReqCtx) error {
+		var name string
+		if err := ctx.Client.Call(ctx.Context(), "name", nil, &name); err != nil {
+			return err
+		}
+		ctx.Res = "hello " + name
+		return ctx.Next()
+	})
+
+	hs := httptest.NewServer(s)
+	defer hs.Close()
+	defer s.Close()
+
+	c := jsonrpc.NewWebSocketClient(time.Second, nil)
+	c.HandleRequest("name", func(ctx *jsonrpc.ReqCtx) error {
+		ctx.Res = "jane"
+		return ctx.Next()
+	})
+	if err := c.Connect("ws"+strings.TrimPrefix(hs.URL, "http"), nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	var res string
+	if err := c.Call(ctx, "greet", nil, &res); err != nil || res != "hello jane" {
+		t.Fatalf("expected handler to get the response of its own request, got: %v, %v", res, err)
+	}
+}
+
+func TestWebSocketFrameType(t *testing.T) {
+	s := jsonrpc.NewWebSocketServer(0)
+	s.UseCodec(jsonrpc.MsgPack)
+	rout, err := jsonrpc.NewRouter(&s.Middleware)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rout.Request("echo", middleware.Echo)
+
+	hs := httptest.NewServer(s)
+	defer hs.Close()
+	defer s.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(hs.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	req, err := jsonrpc.MsgPack.Marshal(jsonrpc.Request{ID: "1", Method: "echo", Params: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.WriteMessage(websocket.BinaryMessage, req); err != nil {
+		t.Fatal(err)
+	}
+
+	ws.SetReadDeadline(time.Now().Add(time.Second * 5))
+	typ, _, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != websocket.BinaryMessage {
+		t.Fatalf("expected binary frame for binary codec, got frame type: %v", typ)
+	}
+}
+
+func TestWebSocketFrameTypeNegotiate(t *testing.T) {
+	s := jsonrpc.NewWebSocketServer(0)
+	s.AcceptNegotiation([]jsonrpc.Codec{jsonrpc.MsgPack})
+	rout, err := jsonrpc.NewRouter(&s.Middleware)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rout.Request("echo", middleware.Echo)
+
+	hs := httptest.NewServer(s)
+	defer hs.Close()
+	defer s.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(hs.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetReadDeadline(time.Now().Add(time.Second * 5))
+
+	// connection switches to msgpack before the response is written, which is still encoded with json
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","id":"1","method":"rpc.negotiate","params":{"codecs":["msgpack"]}}`)); err != nil {
+		t.Fatal(err)
+	}
+	typ, msg, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != websocket.TextMessage || !strings.Contains(string(msg), `"msgpack"`) {
+		t.Fatalf("expected negotiation response in a text frame, got frame type %v: %s", typ, msg)
+	}
+
+	req, err := jsonrpc.MsgPack.Marshal(jsonrpc.Request{ID: "2", Method: "echo", Params: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.WriteMessage(websocket.BinaryMessage, req); err != nil {
+		t.Fatal(err)
+	}
+	if typ, _, err := ws.ReadMessage(); err != nil || typ != websocket.BinaryMessage {
+		t.Fatalf("expected binary frame after switching to msgpack, got frame type %v: %v", typ, err)
+	}
+}
+
+func TestWebSocketClientForgetsPeer(t *testing.T) {
+	s := jsonrpc.NewWebSocketServer(0)
+	rout, err := jsonrpc.NewRouter(&s.Middleware)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rout.Request("echo", middleware.Echo)
+
+	hs := httptest.NewServer(s)
+	defer hs.Close()
+
+	disconnected := make(chan struct{})
+	c := jsonrpc.NewWebSocketClient(0, func(c *jsonrpc.WebSocketClient) { close(disconnected) })
+	peers := make(chan *jsonrpc.Peer, 1)
+	c.ResMiddleware(func(ctx *jsonrpc.ResCtx) error {
+		peers <- ctx.Peer
+		return ctx.Next()
+	})
+	if err := c.Connect("ws"+strings.TrimPrefix(hs.URL, "http"), nil); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := c.Call(ctx, "echo", "hi", nil); err != nil {
+		t.Fatal(err)
+	}
+	peer := <-peers
+
+	s.Close()
+	select {
+	case <-disconnected:
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for disconnection")
+	}
+	select {
+	case <-peer.Context().Done():
+	default:
+		t.Fatal("expected the peer of the closed connection to be forgotten")
+	}
+}
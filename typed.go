@@ -0,0 +1,135 @@
+package jsonrpc
+
+import (
+	"fmt"
+	"reflect"
+)
+
+var (
+	reqCtxType = reflect.TypeOf((*ReqCtx)(nil))
+	notCtxType = reflect.TypeOf((*NotCtx)(nil))
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// nullResult is a non-nil response result which is encoded as null, so a response is still sent when a typed handler returns a nil result.
+var nullResult = (*struct{})(nil)
+
+// methodTypes holds the Go types of params and result of a typed handler.
+type methodTypes struct {
+	params reflect.Type
+	result reflect.Type // nil for notifications
+}
+
+// TypedRequest adds a new request route registry with a typed handler of the form:
+//
+//	func(ctx *ReqCtx, params T) (R, error)
+//
+// Request params are deserialized into T before the handler is called, and returned R is sent as the response result (null if R is nil).
+// If the returned error is a *ResError, it is sent as the response error object.
+// Other errors are answered with -32603 (internal error) code, and then returned to the middleware stack as is.
+// Params and result types are also used for describing the method in rpc.discover document.
+// Optional middleware runs only for this route, before params are deserialized. See Request for details.
+func (r *Router) TypedRequest(route string, handler interface{}, middleware ...func(ctx *ReqCtx) error) error {
+	h := reflect.ValueOf(handler)
+	if !h.IsValid() || h.Kind() == reflect.Func && h.IsNil() {
+		return fmt.Errorf("request handler for %v cannot be nil", route)
+	}
+	t := h.Type()
+	if t.Kind() != reflect.Func || t.NumIn() != 2 || t.In(0) != reqCtxType || t.NumOut() != 2 || t.Out(1) != errorType {
+		return fmt.Errorf("request handler for %v should be of the form func(ctx *ReqCtx, params T) (R, error), got: %v", route, t)
+	}
+
+	paramsType := t.In(1)
+	r.Request(route, func(ctx *ReqCtx) error {
+		params, err := newParams(paramsType, ctx.Params)
+		if err != nil {
+			ctx.Err = &ResError{Code: -32602, Message: "Invalid params", Data: err.Error()}
+			return ctx.Next()
+		}
+
+		out := h.Call([]reflect.Value{reflect.ValueOf(ctx), params})
+		err, _ = out[1].Interface().(error)
+		if resErr, ok := err.(*ResError); ok {
+			if resErr != nil {
+				ctx.Err = resErr
+				return ctx.Next()
+			}
+			err = nil // typed nil *ResError is no error at all
+		}
+		if err != nil {
+			ctx.Err = &ResError{Code: -32603, Message: "Internal error"}
+			if nerr := ctx.Next(); nerr != nil {
+				return nerr
+			}
+			return err
+		}
+
+		ctx.Res = out[0].Interface()
+		if isNil(out[0]) {
+			ctx.Res = nullResult
+		}
+		return ctx.Next()
+	}, middleware...)
+
+	r.mutex.Lock()
+	r.reqTypes[route] = methodTypes{params: paramsType, result: t.Out(0)}
+	r.mutex.Unlock()
+	return nil
+}
+
+// TypedNotification adds a new notification route registry with a typed handler of the form:
+//
+//	func(ctx *NotCtx, params T) error
+//
+// Notification params are deserialized into T before the handler is called.
+// Optional middleware runs only for this route, before params are deserialized. See Request for details.
+func (r *Router) TypedNotification(route string, handler interface{}, middleware ...func(ctx *NotCtx) error) error {
+	h := reflect.ValueOf(handler)
+	if !h.IsValid() || h.Kind() == reflect.Func && h.IsNil() {
+		return fmt.Errorf("notification handler for %v cannot be nil", route)
+	}
+	t := h.Type()
+	if t.Kind() != reflect.Func || t.NumIn() != 2 || t.In(0) != notCtxType || t.NumOut() != 1 || t.Out(0) != errorType {
+		return fmt.Errorf("notification handler for %v should be of the form func(ctx *NotCtx, params T) error, got: %v", route, t)
+	}
+
+	paramsType := t.In(1)
+	r.Notification(route, func(ctx *NotCtx) error {
+		params, err := newParams(paramsType, ctx.Params)
+		if err != nil {
+			return err
+		}
+
+		if err, _ := h.Call([]reflect.Value{reflect.ValueOf(ctx), params})[0].Interface().(error); err != nil {
+			return err
+		}
+
+		return ctx.Next()
+	}, middleware...)
+
+	r.mutex.Lock()
+	r.notTypes[route] = methodTypes{params: paramsType}
+	r.mutex.Unlock()
+	return nil
+}
+
+// isNil tells whether given handler result is a nil interface, pointer, map, or slice.
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice:
+		return v.IsNil()
+	}
+
+	return false
+}
+
+// newParams allocates a value of given type and reads message params into it.
+func newParams(t reflect.Type, read func(v interface{}) error) (reflect.Value, error) {
+	if t.Kind() == reflect.Ptr {
+		v := reflect.New(t.Elem())
+		return v, read(v.Interface())
+	}
+
+	v := reflect.New(t)
+	return v.Elem(), read(v.Interface())
+}
@@ -0,0 +1,111 @@
+package jsonrpc
+
+import "log"
+
+// UnhandledHandler defines the hook registrar functions for the messages that no middleware handles, which is implemented by Middleware.
+type UnhandledHandler interface {
+	UnhandledRequest(hooks ...func(ctx *ReqCtx) error)
+	UnhandledNotification(hooks ...func(ctx *NotCtx) error)
+	UnhandledResponse(hooks ...func(ctx *ResCtx) error)
+}
+
+// UnhandledRequest registers hooks for the requests that are not claimed by any route and not answered by any middleware.
+// Hooks form a middleware stack of their own, which ends with the default handling: logging the request and answering it with -32601 (method not found) error.
+// Hooks calling ctx.Next() keep the default handling (i.e. to count the requests), while hooks setting ctx.Res or ctx.Err and calling ctx.Respond() replace it.
+func (mw *Middleware) UnhandledRequest(hooks ...func(ctx *ReqCtx) error) {
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+
+	mw.unhandledReq = append(mw.unhandledReq, hooks...)
+}
+
+// UnhandledNotification registers hooks for the notifications that are not claimed by any route or listener.
+// Hooks form a middleware stack of their own, which ends with logging the notification. See UnhandledRequest for details.
+func (mw *Middleware) UnhandledNotification(hooks ...func(ctx *NotCtx) error) {
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+
+	mw.unhandledNot = append(mw.unhandledNot, hooks...)
+}
+
+// UnhandledResponse registers hooks for the responses with IDs that no request is waiting for (i.e. late responses of timed out calls).
+// Hooks form a middleware stack of their own, which ends with logging the response. See UnhandledRequest for details.
+func (mw *Middleware) UnhandledResponse(hooks ...func(ctx *ResCtx) error) {
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+
+	mw.unhandledRes = append(mw.unhandledRes, hooks...)
+}
+
+// UseDeadLetter sets a sink capturing the raw messages (decompressed) of all unhandled requests, notifications, and responses for debugging.
+// Sink is called only for the messages reaching the default handling (i.e. not answered by any of the unhandled message hooks),
+// from the goroutine handling the message. Passing nil removes the sink.
+func (mw *Middleware) UseDeadLetter(sink func(connID string, msg []byte)) {
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+
+	mw.deadLetter = sink
+}
+
+// unhandledRequest is the last middleware of the request stack before the response writer, which passes unhandled requests through the hooks.
+func (mw *Middleware) unhandledRequest(ctx *ReqCtx) error {
+	if ctx.handled || ctx.Res != nil || ctx.Err != nil {
+		return ctx.Next()
+	}
+
+	mw.mutex.RLock()
+	hooks, sink := mw.unhandledReq[:len(mw.unhandledReq):len(mw.unhandledReq)], mw.deadLetter
+	mw.mutex.RUnlock()
+
+	return ctx.nest(append(hooks, func(ctx *ReqCtx) error {
+		if sink != nil {
+			sink(ctx.Client.ConnID(), ctx.raw)
+		}
+		log.Printf("jsonrpc: unhandled request %v from %v", ctx.method, ctx.Client.ConnID())
+		if ctx.Res == nil && ctx.Err == nil {
+			ctx.Err = &ResError{Code: -32601, Message: "Method not found"}
+		}
+		return ctx.Next()
+	}), ctx.route)
+}
+
+// unhandledNotification is the last middleware of the notification stack, which passes unhandled notifications through the hooks.
+func (mw *Middleware) unhandledNotification(ctx *NotCtx) error {
+	if ctx.handled {
+		return ctx.Next()
+	}
+
+	mw.mutex.RLock()
+	hooks, sink := mw.unhandledNot[:len(mw.unhandledNot):len(mw.unhandledNot)], mw.deadLetter
+	mw.mutex.RUnlock()
+
+	return ctx.nest(append(hooks, func(ctx *NotCtx) error {
+		if sink != nil {
+			sink(ctx.Client.ConnID(), ctx.raw)
+		}
+		log.Printf("jsonrpc: unhandled notification %v from %v", ctx.method, ctx.Client.ConnID())
+		return ctx.Next()
+	}), ctx.route)
+}
+
+// unhandledResponse is the last middleware of the response stack, which passes unhandled responses through the hooks.
+func (mw *Middleware) unhandledResponse(ctx *ResCtx) error {
+	if ctx.handled {
+		return ctx.Next()
+	}
+
+	mw.mutex.RLock()
+	hooks, sink := mw.unhandledRes[:len(mw.unhandledRes):len(mw.unhandledRes)], mw.deadLetter
+	mw.mutex.RUnlock()
+
+	// this is the last middleware of the stack, so the stack is replaced by the hooks rather than being nested
+	ctx.mw = append(hooks, func(ctx *ResCtx) error {
+		if sink != nil {
+			sink(ctx.Client.ConnID(), ctx.raw)
+		}
+		log.Printf("jsonrpc: unhandled response %v from %v", ctx.id, ctx.Client.ConnID())
+		return ctx.Next()
+	})
+	ctx.mwIndex = 0
+	return ctx.Next()
+}
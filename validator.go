@@ -0,0 +1,314 @@
+package jsonrpc
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Violation is a single JSON Schema validation failure.
+type Violation struct {
+	Path    string `json:"path"` // JSON pointer (RFC 6901) to the invalid value, which is empty for the params object itself
+	Message string `json:"message"`
+}
+
+// Validator is a middleware validating request and notification params against the JSON Schemas registered per route.
+// Requests with invalid params are answered with -32602 error code, listing all the violations in the error data.
+// Notifications with invalid params are dropped.
+type Validator struct {
+	reqSchemas map[string]*Schema        // route -> params schema
+	notSchemas map[string]*Schema        // route -> params schema
+	patterns   map[string]*regexp.Regexp // pattern -> compiled regexp : patterns of all the registered schemas
+}
+
+// NewValidator creates a JSON Schema validator instance and registers it as a Neptulon JSON-RPC middleware.
+// Validator should be registered before any Router for the validation to take place before handlers run.
+func NewValidator(m RouteHandler) (*Validator, error) {
+	if m == nil {
+		return nil, errors.New("given JSON-RPC Middleware instance is nil")
+	}
+
+	v := Validator{
+		reqSchemas: make(map[string]*Schema),
+		notSchemas: make(map[string]*Schema),
+		patterns:   make(map[string]*regexp.Regexp),
+	}
+
+	m.ReqMiddleware(v.reqMiddleware)
+	m.NotMiddleware(v.notMiddleware)
+	return &v, nil
+}
+
+// Request registers a params schema for a request route. Use SchemaOf to generate schemas from Go types.
+// Route is the method name relative to the prefix of the router group that the validator is registered on (if any), same as the routes of the group.
+// Returns an error if the schema has an invalid pattern.
+func (v *Validator) Request(route string, schema *Schema) error {
+	if err := schema.compilePatterns(v.patterns); err != nil {
+		return err
+	}
+
+	v.reqSchemas[route] = schema
+	return nil
+}
+
+// Notification registers a params schema for a notification route. Use SchemaOf to generate schemas from Go types.
+// Returns an error if the schema has an invalid pattern.
+func (v *Validator) Notification(route string, schema *Schema) error {
+	if err := schema.compilePatterns(v.patterns); err != nil {
+		return err
+	}
+
+	v.notSchemas[route] = schema
+	return nil
+}
+
+func (v *Validator) reqMiddleware(ctx *ReqCtx) error {
+	schema, ok := v.reqSchemas[ctx.route]
+	if !ok {
+		return ctx.Next()
+	}
+
+	var params interface{}
+	if err := ctx.Params(&params); err != nil {
+		ctx.Err = &ResError{Code: -32602, Message: "Invalid params", Data: err.Error()}
+		return ctx.Respond()
+	}
+
+	if violations := schema.validateAll(params, v.patterns); len(violations) != 0 {
+		ctx.Err = &ResError{Code: -32602, Message: "Invalid params", Data: violations}
+		return ctx.Respond()
+	}
+
+	return ctx.Next()
+}
+
+// notMiddleware drops notifications with invalid params, as notifications cannot be answered with an error.
+func (v *Validator) notMiddleware(ctx *NotCtx) error {
+	schema, ok := v.notSchemas[ctx.route]
+	if !ok {
+		return ctx.Next()
+	}
+
+	var params interface{}
+	if err := ctx.Params(&params); err != nil {
+		return fmt.Errorf("invalid params for notification %v: %v", ctx.method, err)
+	}
+
+	if violations := schema.validateAll(params, v.patterns); len(violations) != 0 {
+		return fmt.Errorf("invalid params for notification %v: %+v", ctx.method, violations)
+	}
+
+	return ctx.Next()
+}
+
+// Validate validates a schema-less decoded value (i.e. decoded into interface{}) against the schema and returns all the violations found.
+// Patterns are compiled on every call, so prefer registering the schema with a Validator for repeated validation.
+func (s *Schema) Validate(v interface{}) []Violation {
+	return s.validateAll(v, nil)
+}
+
+// validateAll validates given value using the precompiled patterns. Patterns missing from the map are compiled on the fly.
+func (s *Schema) validateAll(v interface{}, patterns map[string]*regexp.Regexp) []Violation {
+	var violations []Violation
+	s.validate("", v, patterns, &violations)
+	return violations
+}
+
+// compilePatterns compiles the patterns of the schema and its subschemas into given map.
+func (s *Schema) compilePatterns(patterns map[string]*regexp.Regexp) error {
+	if s == nil {
+		return nil
+	}
+
+	if _, ok := patterns[s.Pattern]; s.Pattern != "" && !ok {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern in schema: %v", err)
+		}
+		patterns[s.Pattern] = re
+	}
+
+	for _, ps := range s.Properties {
+		if err := ps.compilePatterns(patterns); err != nil {
+			return err
+		}
+	}
+	if err := s.Items.compilePatterns(patterns); err != nil {
+		return err
+	}
+
+	return s.AdditionalProperties.compilePatterns(patterns)
+}
+
+func (s *Schema) validate(path string, v interface{}, patterns map[string]*regexp.Regexp, violations *[]Violation) {
+	fail := func(format string, args ...interface{}) {
+		*violations = append(*violations, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.Type != "" && !hasType(v, s.Type) {
+		fail("expected %v, got %v", s.Type, typeName(v))
+		return
+	}
+
+	if len(s.Enum) != 0 {
+		found := false
+		for _, e := range s.Enum {
+			if equal(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("value is not one of %v", s.Enum)
+		}
+	}
+
+	switch val := v.(type) {
+	case string:
+		l := utf8.RuneCountInString(val)
+		if s.MinLength != nil && l < *s.MinLength {
+			fail("string is shorter than %v characters", *s.MinLength)
+		}
+		if s.MaxLength != nil && l > *s.MaxLength {
+			fail("string is longer than %v characters", *s.MaxLength)
+		}
+		if s.Pattern != "" {
+			re, ok := patterns[s.Pattern]
+			var err error
+			if !ok {
+				re, err = regexp.Compile(s.Pattern)
+			}
+			if err != nil {
+				fail("invalid pattern in schema: %v", err)
+			} else if !re.MatchString(val) {
+				fail("string does not match pattern %v", s.Pattern)
+			}
+		}
+
+	case []interface{}:
+		if s.MinItems != nil && len(val) < *s.MinItems {
+			fail("array has less than %v items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(val) > *s.MaxItems {
+			fail("array has more than %v items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range val {
+				s.Items.validate(path+"/"+strconv.Itoa(i), item, patterns, violations)
+			}
+		}
+
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := val[name]; !ok {
+				*violations = append(*violations, Violation{Path: path + "/" + escapePointer(name), Message: "required property is missing"})
+			}
+		}
+
+		names := make([]string, 0, len(val))
+		for name := range val {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if ps, ok := s.Properties[name]; ok {
+				ps.validate(path+"/"+escapePointer(name), val[name], patterns, violations)
+			} else if s.AdditionalProperties != nil {
+				s.AdditionalProperties.validate(path+"/"+escapePointer(name), val[name], patterns, violations)
+			}
+		}
+
+	default:
+		if n, ok := toFloat(v); ok {
+			if s.Minimum != nil && n < *s.Minimum {
+				fail("value is less than minimum %v", *s.Minimum)
+			}
+			if s.Maximum != nil && n > *s.Maximum {
+				fail("value is greater than maximum %v", *s.Maximum)
+			}
+		}
+	}
+}
+
+func hasType(v interface{}, typ string) bool {
+	switch typ {
+	case "null":
+		return v == nil
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := toFloat(v)
+		return ok
+	case "integer":
+		n, ok := toFloat(v)
+		return ok && n == math.Trunc(n)
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return ok
+	}
+
+	return true // unknown types are not validated
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+
+	if _, ok := toFloat(v); ok {
+		return "number"
+	}
+
+	return fmt.Sprintf("%T", v)
+}
+
+// toFloat converts any numeric value produced by the codecs to float64.
+func toFloat(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+
+	return 0, false
+}
+
+func equal(a, b interface{}) bool {
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		return ok && x == y
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// escapePointer escapes a property name to be used as a JSON pointer reference token.
+func escapePointer(name string) string {
+	return strings.Replace(strings.Replace(name, "~", "~0", -1), "/", "~1", -1)
+}
@@ -0,0 +1,365 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/neptulon/cmap"
+	"github.com/neptulon/shortid"
+)
+
+// wsWriteWait is the time allowed to write a single frame to the peer.
+const wsWriteWait = 10 * time.Second
+
+var errNotConnected = errors.New("client is not connected")
+
+// WebSocketServer is a JSON-RPC server accepting WebSocket connections.
+// Each frame carries exactly one JSON-RPC message, in text frames for JSON and in binary frames for binary codecs.
+type WebSocketServer struct {
+	Middleware
+	Sender
+	Upgrader       websocket.Upgrader // Upgrader used for incoming HTTP connections. Set CheckOrigin to accept cross-origin browser clients.
+	NotifyShutdown bool               // Send ShutdownMethod notification to the connections on Shutdown.
+
+	keepalive time.Duration
+	mutex     sync.RWMutex
+	conns     map[string]*wsConn // connection ID -> connection
+}
+
+// NewWebSocketServer creates a JSON-RPC over WebSocket server which can be mounted on any http.ServeMux.
+// keepalive = (optional) ping interval to detect dead connections with. A connection is dropped after two intervals of silence.
+func NewWebSocketServer(keepalive time.Duration) *WebSocketServer {
+	s := WebSocketServer{
+		keepalive: keepalive,
+		conns:     make(map[string]*wsConn),
+	}
+
+	s.Sender = NewSender(&s.Middleware, s.send)
+	return &s
+}
+
+// ServeHTTP upgrades the incoming HTTP connection to a WebSocket connection and starts receiving messages.
+// It returns only after the connection is closed.
+func (s *WebSocketServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws, err := s.Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // upgrader already replied with an HTTP error
+	}
+
+	c, err := newWSConn(ws, s.keepalive)
+	if err != nil {
+		ws.Close()
+		return
+	}
+
+	s.mutex.Lock()
+	s.conns[c.id] = c
+	s.mutex.Unlock()
+
+	s.Middleware.Serve(c)
+	c.Close()
+
+	s.mutex.Lock()
+	delete(s.conns, c.id)
+	s.mutex.Unlock()
+	s.forgetConn(c.id)
+}
+
+// Close closes all active connections.
+func (s *WebSocketServer) Close() error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, c := range s.conns {
+		c.Close()
+	}
+
+	return nil
+}
+
+func (s *WebSocketServer) send(connID string, msg []byte) error {
+	s.mutex.RLock()
+	c, ok := s.conns[connID]
+	s.mutex.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("connection not found: %v", connID)
+	}
+
+	return c.Send(msg)
+}
+
+// WebSocketClient is a JSON-RPC client connecting to a WebSocket server.
+type WebSocketClient struct {
+	Middleware
+
+	sender         Sender
+	conn           *wsConn
+	session        *cmap.CMap // session of the connection, which is available before connecting as well
+	router         *Router
+	keepalive      time.Duration
+	disconnHandler func(client *WebSocketClient)
+}
+
+// NewWebSocketClient creates a new WebSocketClient object.
+// keepalive = (optional) ping interval to detect dead connections with. The connection is dropped after two intervals of silence.
+// disconnHandler = (optional) registers a function to handle client disconnection events.
+func NewWebSocketClient(keepalive time.Duration, disconnHandler func(client *WebSocketClient)) *WebSocketClient {
+	c := WebSocketClient{
+		session:        cmap.New(),
+		keepalive:      keepalive,
+		disconnHandler: disconnHandler,
+	}
+
+	c.sender = NewSender(&c.Middleware, func(connID string, msg []byte) error {
+		if c.conn == nil {
+			return errNotConnected
+		}
+		return c.conn.Send(msg)
+	})
+	return &c
+}
+
+// Connect connects to the server at given WebSocket URL (i.e. ws://127.0.0.1:3000/rpc) and starts receiving messages.
+// header = (optional) HTTP headers to send along with the handshake request (i.e. Origin, Authorization).
+func (c *WebSocketClient) Connect(url string, header http.Header) error {
+	ws, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		return err
+	}
+
+	if c.conn, err = newWSConn(ws, c.keepalive); err != nil {
+		ws.Close()
+		return err
+	}
+	c.conn.session = c.session
+
+	go func() {
+		c.Middleware.Serve(c.conn)
+		c.conn.Close()
+		c.forgetConn(c.conn.ConnID())
+		if c.disconnHandler != nil {
+			c.disconnHandler(c)
+		}
+	}()
+
+	return nil
+}
+
+// ConnID is a randomly generated unique client connection ID. It is empty until the client is connected.
+func (c *WebSocketClient) ConnID() string {
+	if c.conn == nil {
+		return ""
+	}
+
+	return c.conn.ConnID()
+}
+
+// Session is a thread-safe data store for storing arbitrary data for this connection session.
+func (c *WebSocketClient) Session() *cmap.CMap {
+	return c.session
+}
+
+// SendRequest sends a JSON-RPC request through the client connection with an auto generated request ID.
+// resHandler is called when a response is returned.
+func (c *WebSocketClient) SendRequest(method string, params interface{}, resHandler func(ctx *ResCtx) error) (reqID string, err error) {
+	return c.sender.SendRequest("", method, params, resHandler)
+}
+
+// SendRequestArr sends a JSON-RPC request through the client connection, with array params and auto generated request ID.
+// resHandler is called when a response is returned.
+func (c *WebSocketClient) SendRequestArr(method string, resHandler func(ctx *ResCtx) error, params ...interface{}) (reqID string, err error) {
+	return c.sender.SendRequestArr("", method, resHandler, params...)
+}
+
+// Call sends a JSON-RPC request through the client connection and blocks until the response arrives or given context is done.
+// See Sender.Call for details.
+func (c *WebSocketClient) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	return c.sender.Call(ctx, "", method, params, result)
+}
+
+// SendNotification sends a JSON-RPC notification through the client connection with structured params object.
+func (c *WebSocketClient) SendNotification(method string, params interface{}) error {
+	return c.sender.SendNotification("", method, params)
+}
+
+// SendNotificationArr sends a JSON-RPC notification message through the client connection with array params.
+func (c *WebSocketClient) SendNotificationArr(method string, params ...interface{}) error {
+	return c.sender.SendNotificationArr("", method, params...)
+}
+
+// HandleRequest regiters a handler for incoming requests.
+func (c *WebSocketClient) HandleRequest(route string, handler func(ctx *ReqCtx) error, middleware ...func(ctx *ReqCtx) error) {
+	c.lazyRegisterRouter()
+	c.router.Request(route, handler, middleware...)
+}
+
+// HandleNotification regiters a handler for incoming notifications.
+func (c *WebSocketClient) HandleNotification(route string, handler func(ctx *NotCtx) error, middleware ...func(ctx *NotCtx) error) {
+	c.lazyRegisterRouter()
+	c.router.Notification(route, handler, middleware...)
+}
+
+// Subscribe adds a listener for incoming notifications, alongside the handler and the other listeners of the route. See Router.Subscribe for details.
+func (c *WebSocketClient) Subscribe(route string, listener func(ctx *NotCtx) error) (unsubscribe func()) {
+	c.lazyRegisterRouter()
+	return c.router.Subscribe(route, listener)
+}
+
+// Close closes a client connection.
+func (c *WebSocketClient) Close() error {
+	if c.conn == nil {
+		return errNotConnected
+	}
+
+	return c.conn.Close()
+}
+
+// Router middleware needs to be registered last for other middleware to be relevant.
+func (c *WebSocketClient) lazyRegisterRouter() {
+	if c.router == nil {
+		c.router, _ = NewRouter(c)
+	}
+}
+
+// wsConn is a WebSocket connection carrying one JSON-RPC message per frame.
+type wsConn struct {
+	id        string
+	ws        *websocket.Conn
+	session   *cmap.CMap
+	keepalive time.Duration
+	writeMu   sync.Mutex // WebSocket connections support only one concurrent writer
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newWSConn(ws *websocket.Conn, keepalive time.Duration) (*wsConn, error) {
+	id, err := shortid.UUID()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &wsConn{
+		id:        id,
+		ws:        ws,
+		session:   cmap.New(),
+		keepalive: keepalive,
+		done:      make(chan struct{}),
+	}
+
+	// frames are read into memory as a whole, so their size is limited as the messages of the stream transport are
+	ws.SetReadLimit(DefaultMaxMessageSize)
+	if keepalive > 0 {
+		c.keepAlive()
+	}
+
+	return c, nil
+}
+
+// ConnID is a randomly generated unique connection ID.
+func (c *wsConn) ConnID() string {
+	return c.id
+}
+
+// Session is a thread-safe data store for storing arbitrary data for this connection session.
+func (c *wsConn) Session() *cmap.CMap {
+	return c.session
+}
+
+// Send writes the given message to the connection as a single frame.
+// Text frames are used for text codecs (i.e. JSON) and binary frames for binary codecs (i.e. MessagePack) and compressed messages.
+// Frame type is picked by the message itself rather than the codec of the connection, as the codec might change while responses encoded with the earlier one are being sent (see Negotiate).
+func (c *wsConn) Send(msg []byte) error {
+	typ := websocket.BinaryMessage
+	if isJSON(msg) {
+		typ = websocket.TextMessage
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.ws.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return c.ws.WriteMessage(typ, msg)
+}
+
+// Close sends a close frame to the peer and closes the connection.
+func (c *wsConn) Close() error {
+	err := errors.New("connection already closed")
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(wsWriteWait))
+		err = c.ws.Close()
+	})
+
+	return err
+}
+
+// Receive blocks until the next text or binary frame is read from the connection. Control frames are handled internally.
+func (c *wsConn) Receive() ([]byte, error) {
+	_, msg, err := c.ws.ReadMessage()
+	if err != nil {
+		select {
+		case <-c.done:
+			return nil, io.EOF
+		default:
+		}
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	if c.keepalive > 0 {
+		c.extendDeadline()
+	}
+
+	return msg, nil
+}
+
+// keepAlive starts the ping loop and extends the read deadline whenever the peer shows a sign of life.
+func (c *wsConn) keepAlive() {
+	c.extendDeadline()
+	c.ws.SetPongHandler(func(string) error {
+		c.extendDeadline()
+		return nil
+	})
+	c.ws.SetPingHandler(func(data string) error {
+		c.extendDeadline()
+		return c.ws.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(wsWriteWait))
+	})
+	go c.ping()
+}
+
+// ping sends a ping frame to the peer at every keepalive interval until the connection is closed.
+func (c *wsConn) ping() {
+	ticker := time.NewTicker(c.keepalive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				c.Close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// isJSON tells whether the message is a JSON object or array, which cannot be the case for a binary encoded or compressed message.
+func isJSON(msg []byte) bool {
+	msg = bytes.TrimLeft(msg, " \t\r\n")
+	return len(msg) != 0 && (msg[0] == '{' || msg[0] == '[')
+}
+
+func (c *wsConn) extendDeadline() {
+	c.ws.SetReadDeadline(time.Now().Add(2 * c.keepalive))
+}